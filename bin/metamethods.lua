@@ -0,0 +1,162 @@
+---
+--- Conformance checks for the metamethods of Lua 5.3
+--- Usage: lua metamethods.lua (prints "OK" when every check passes)
+---
+
+local function errmsg(f, ...)
+    local ok, msg = pcall(f, ...)
+    assert(not ok, "error expected")
+    return msg
+end
+
+print("\n---arithmetic---")
+local V = {}
+V.__index = V
+local function vec(x) return setmetatable({ x = x }, V) end
+local function val(a) return type(a) == "table" and a.x or a end
+for _, e in ipairs { "add", "sub", "mul", "div", "mod", "pow", "unm", "idiv",
+                     "band", "bor", "bxor", "shl", "shr", "bnot", "concat" } do
+    V["__" .. e] = function(a, b) return e .. "(" .. tostring(val(a)) .. "," .. tostring(val(b)) .. ")" end
+end
+local v = vec(1)
+assert(v + 2 == "add(1,2)")
+assert(2 + v == "add(2,1)") -- metamethod of the second operand
+assert(v - v == "sub(1,1)")
+assert(3 * v == "mul(3,1)")
+assert(v / 4 == "div(1,4)")
+assert(5 % v == "mod(5,1)")
+assert(v ^ 6 == "pow(1,6)")
+assert(-v == "unm(1,1)") -- unary operators get the operand twice
+assert(7 // v == "idiv(7,1)")
+assert(v & 1 == "band(1,1)")
+assert(1 | v == "bor(1,1)")
+assert(v ~ 2 == "bxor(1,2)")
+assert(v << 3 == "shl(1,3)")
+assert(4 >> v == "shr(4,1)")
+assert(~v == "bnot(1,1)")
+assert(v .. "s" == "concat(1,s)")
+assert("s" .. v == "concat(s,1)")
+assert(1 .. v .. 2 == "1concat(1,2)") -- right associative
+assert(errmsg(function() return {} + 1 end):find("attempt to perform arithmetic on a table value"))
+assert(errmsg(function() return 1.5 | 1 end):find("number has no integer representation"))
+print("ok")
+
+print("\n---comparison---")
+local C = {}
+C.__lt = function(a, b) return val(a) < val(b) end
+local function cmp(x) return setmetatable({ x = x }, C) end
+local a, b = cmp(1), cmp(2)
+assert(a < b and not (b < a))
+assert(a > 0 and 3 > b and not (a > 1)) -- mixed operands use either metamethod
+-- without __le, a <= b is not (b < a)
+assert(a <= b and not (b <= a) and a <= cmp(1))
+assert(a >= a and b >= a)
+C.__le = function() return "yes" end -- results are converted to booleans
+assert((b <= a) == true)
+assert(errmsg(function() return {} < {} end):find("attempt to compare two table values"))
+assert(errmsg(function() return {} <= 1 end):find("attempt to compare table with number"))
+local named = setmetatable({}, { __name = "MyType" })
+assert(errmsg(function() return named < 1 end):find("attempt to compare MyType with number"))
+
+local E = { __eq = function(a, b) return val(a) == val(b) end }
+local e1, e2 = setmetatable({ x = 1 }, E), setmetatable({ x = 1 }, E)
+assert(e1 == e2 and not (e1 ~= e2))
+assert(e1 == setmetatable({ x = 1 }, {})) -- metamethod of the first operand
+assert(setmetatable({ x = 1 }, {}) == e1) -- or else of the second one
+assert(e1 ~= 1 and e1 ~= "x") -- values of different types are never equal
+assert(rawequal(e1, e1) and not rawequal(e1, e2))
+local calls = 0
+local same = setmetatable({}, { __eq = function() calls = calls + 1 return false end })
+assert(same == same and calls == 0) -- identical values do not call __eq
+print("ok")
+
+print("\n---index---")
+local base = { greet = function() return "hi" end }
+local derived = setmetatable({}, { __index = base })
+local leaf = setmetatable({}, { __index = derived })
+assert(leaf.greet() == "hi" and leaf.missing == nil)
+local fidx = setmetatable({}, { __index = function(t, k) return k .. "!" end })
+assert(fidx.foo == "foo!" and fidx[1] == "1!")
+assert(rawget(fidx, "foo") == nil)
+local goidx = setmetatable({}, { __index = rawequal }) -- a Go function
+assert(goidx.x == false)
+local loop = {}
+setmetatable(loop, { __index = loop })
+assert(errmsg(function() return loop.x end):find("'__index' chain too long; possible loop"))
+local chain = {}
+for i = 1, 100 do chain = setmetatable({}, { __index = chain }) end
+assert(chain.x == nil)
+assert(errmsg(function() local n = nil; return n.x end):find("attempt to index a nil value"))
+
+local log = {}
+local store = {}
+local proxy = setmetatable({}, { __newindex = store })
+proxy.a = 1
+assert(rawget(proxy, "a") == nil and store.a == 1)
+local fnew = setmetatable({}, { __newindex = function(t, k, v) log[#log + 1] = k; rawset(t, k, v) end })
+fnew.x = 1
+fnew.x = 2 -- existing keys do not call __newindex
+assert(#log == 1 and log[1] == "x" and fnew.x == 2)
+local nloop = {}
+setmetatable(nloop, { __newindex = nloop })
+assert(errmsg(function() nloop.x = 1 end):find("'__newindex' chain too long; possible loop"))
+rawset(proxy, "b", 2)
+assert(proxy.b == 2 and store.b == nil)
+print("ok")
+
+print("\n---strings---")
+assert(("abc"):upper() == "ABC")
+assert(("x"):rep(3) == "xxx")
+assert(getmetatable("").__index == string)
+assert(errmsg(function() return ("abc"):nomethod() end):find("attempt to call a nil value"))
+print("ok")
+
+print("\n---call---")
+local callable = setmetatable({}, { __call = function(self, a, b) return self, a + b end })
+local s, sum = callable(1, 2)
+assert(s == callable and sum == 3)
+assert(select(2, pcall(callable, 3, 4)) == callable)
+local function tail(...) return callable(...) end
+assert(select(2, tail(5, 6)) == 11)
+local golen = setmetatable({ 1, 2, 3 }, { __call = rawlen }) -- a Go function
+assert(golen() == 3)
+assert(errmsg(function() setmetatable({}, { __call = 1 })() end):find("attempt to call a table value"))
+print("ok")
+
+print("\n---len---")
+local L = setmetatable({ 1, 2 }, { __len = function() return 42 end })
+assert(#L == 42 and rawlen(L) == 2)
+assert(errmsg(function() return #5 end):find("attempt to get length of a number value"))
+print("ok")
+
+print("\n---tostring---")
+local T = setmetatable({}, { __tostring = function() return "T!" end })
+assert(tostring(T) == "T!")
+assert(string.format("%s", T) == "T!")
+local bad = setmetatable({}, { __tostring = function() return {} end })
+assert(errmsg(tostring, bad):find("'__tostring' must return a string"))
+assert(tostring(named):find("^MyType: "))
+print("ok")
+
+print("\n---pairs---")
+local P = setmetatable({}, { __pairs = function(t)
+    local i = 0
+    return function() i = i + 1; if i <= 3 then return i, i * i end end, t, nil
+end })
+local n = 0
+for k, v in pairs(P) do
+    assert(v == k * k)
+    n = n + 1
+end
+assert(n == 3)
+local plain = setmetatable({ a = 1 }, {})
+for k, v in pairs(plain) do assert(k == "a" and v == 1) end
+print("ok")
+
+print("\n---metatable---")
+local prot = setmetatable({}, { __metatable = "locked" })
+assert(getmetatable(prot) == "locked")
+assert(errmsg(setmetatable, prot, {}):find("cannot change a protected metatable"))
+print("ok")
+
+print("\nOK")
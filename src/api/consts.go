@@ -1,10 +1,21 @@
 package api
 
 const (
-	LUA_MINSATCK            = 20
-	LUAI_MAXSTACK           = 1000000
-	LUA_REGISTRYINDEX       = -LUAI_MAXSTACK - 1000
-	LUA_RIDX_GLOBALS  int64 = 2
+	LUA_MINSATCK              = 20
+	LUAI_MAXSTACK             = 1000000
+	LUA_REGISTRYINDEX         = -LUAI_MAXSTACK - 1000
+	LUA_RIDX_MAINTHREAD int64 = 1
+	LUA_RIDX_GLOBALS    int64 = 2
+	LUA_MULTRET               = -1
+)
+
+// key, in the registry, for table of loaded modules
+const LUA_LOADED_TABLE = "_LOADED"
+
+// predefined references (see AuxLib.Ref)
+const (
+	LUA_NOREF  = -2
+	LUA_REFNIL = -1
 )
 
 // basic types
@@ -63,6 +74,36 @@ const (
 	LUA_ERRFILE
 )
 
+// event codes
+const (
+	LUA_HOOKCALL = iota
+	LUA_HOOKRET
+	LUA_HOOKLINE
+	LUA_HOOKCOUNT
+	LUA_HOOKTAILCALL
+)
+
+// event masks
+const (
+	LUA_MASKCALL  = 1 << LUA_HOOKCALL
+	LUA_MASKRET   = 1 << LUA_HOOKRET
+	LUA_MASKLINE  = 1 << LUA_HOOKLINE
+	LUA_MASKCOUNT = 1 << LUA_HOOKCOUNT
+)
+
+// garbage-collection options
+const (
+	LUA_GCSTOP       = 0
+	LUA_GCRESTART    = 1
+	LUA_GCCOLLECT    = 2
+	LUA_GCCOUNT      = 3
+	LUA_GCCOUNTB     = 4
+	LUA_GCSTEP       = 5
+	LUA_GCSETPAUSE   = 6
+	LUA_GCSETSTEPMUL = 7
+	LUA_GCISRUNNING  = 9
+)
+
 // comparison functions
 const (
 	LUA_OPEQ = iota
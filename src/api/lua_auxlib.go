@@ -1,36 +1,72 @@
 package api
 
+import (
+	"context"
+	"io"
+)
+
 type FuncReg map[string]GoFunction
 
+// lua-5.3.4/src/lauxlib.h#luaL_Buffer
+// string built piece by piece by a Go function; the bytes it takes are
+// charged to the state as it grows, so that a memory error is raised
+// before a string too large for the memory limit is built
+type Buffer interface {
+	io.Writer
+	Grow(n int)                        // make room for n more bytes
+	WriteByte(c byte) error            // add c
+	WriteString(s string) (int, error) // add s
+	Len() int                          // bytes added so far
+	PushResult()                       // push(the string built)
+}
+
 type AuxLib interface {
 	/* Error-report functions */
-	Error2(fmt string, a ...interface{}) int // todo
-	ArgError(arg int, extraMsg string) int   // todo
-	Where(lvl int)                           //
+	Error2(fmt string, a ...interface{}) int // raise where(1) .. fmt
+	ArgError(arg int, extraMsg string) int   // raise "bad argument #arg to 'f' (extraMsg)"
+	Where(lvl int)                           // push "chunkname:currentline:" of level lvl
 	/* Argument check functions */
-	CheckStack2(sz int, msg string)               //
-	ArgCheck(cond bool, arg int, extraMsg string) //
-	CheckAny(arg int)                             // r[arg] is None ?
-	CheckType(arg int, t LuaType)                 // r[arg] is LuaType ?
-	CheckInteger(arg int) int64                   // r[arg] is LuaInteger ?
-	CheckNumber(arg int) float64                  // r[arg] is LuaNumber ?
-	CheckString(arg int) string                   // r[arg] is string ?
-	OptInteger(arg int, d int64) int64            // r[arg] or d
-	OptNumber(arg int, d float64) float64         // r[arg] or d
-	OptString(arg int, d string) string           // r[arg] or d
+	CheckStack2(sz int, msg string)                    //
+	ArgCheck(cond bool, arg int, extraMsg string)      //
+	CheckAny(arg int)                                  // r[arg] is None ?
+	CheckType(arg int, t LuaType)                      // r[arg] is LuaType ?
+	CheckInteger(arg int) int64                        // r[arg] is LuaInteger ?
+	CheckNumber(arg int) float64                       // r[arg] is LuaNumber ?
+	CheckString(arg int) string                        // r[arg] is string ?
+	CheckOption(arg int, def string, lst []string) int // r[arg] is one of lst ?
+	OptInteger(arg int, d int64) int64                 // r[arg] or d
+	OptNumber(arg int, d float64) float64              // r[arg] or d
+	OptString(arg int, d string) string                // r[arg] or d
+	TestUData(arg int, tname string) interface{}       // r[arg] is userdata of type tname ?
+	CheckUData(arg int, tname string) interface{}      // r[arg] is userdata of type tname !
 	/* Load functions */
-	DoFile(filename string) bool                  //
-	DoString(str string) bool                     //
-	LoadFile(filename string) ThreadStatus        //
-	LoadFileX(filename, mode string) ThreadStatus //
-	LoadString(s string) ThreadStatus             //
+	DoFile(filename string) bool                             //
+	DoString(str string) bool                                //
+	LoadFile(filename string) ThreadStatus                   //
+	LoadFileX(filename, mode string) ThreadStatus            //
+	LoadFileEnv(filename, mode string, env int) ThreadStatus // LoadFileX with r[env] as _ENV, see LoadEnv
+	LoadString(s string) ThreadStatus                        //
+	/* Go error functions, failures are returned as *LuaError */
+	CallE(nArgs, nResults int) error                            // protected Call, with a traceback
+	CallContext(ctx context.Context, nArgs, nResults int) error // CallE, interrupted when ctx is done
+	DoFileE(filename string) error                              //
+	DoStringE(str string) error                                 //
+	LoadFileE(filename string) error                            //
+	LoadStringE(s string) error                                 //
+	/* String buffer functions */
+	NewBuffer() Buffer // empty buffer of this state
 	/* Other functions */
 	CheckVersion()                                       //
 	TypeName2(idx int) string                            // typename(type(idx))
 	ToString2(idx int) string                            //
 	Len2(idx int) int64                                  // #(r[idx])
 	GetSubTable(idx int, fname string) bool              // push(r[idx][fname] || {})
+	Ref(t int) int                                       // r[t][ref] = pop(); return ref
+	Unref(t, ref int)                                    // r[t][ref] = nil, ref can be reused
 	GetMetafield(obj int, e string) LuaType              // v=r[obj]; mt=v.mt; f=mt[e]; push(f)
+	NewMetatable(tname string) bool                      // push(registry[tname] || {__name=tname})
+	GetMetatable2(tname string) LuaType                  // push(registry[tname])
+	SetMetatable2(tname string)                          // r[-1].mt = registry[tname]
 	CallMeta(obj int, e string) bool                     // v=r[obj]; mt=v.mt; f=mt[e]; f(v)
 	OpenLibs()                                           //
 	RequireF(modname string, openf GoFunction, glb bool) //
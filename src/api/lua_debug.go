@@ -0,0 +1,22 @@
+package api
+
+// activation record of a function, see lua_Debug
+type Debug struct {
+	Event           int         // LUA_HOOKCALL, LUA_HOOKLINE... when passed to a hook
+	Name            string      // (n) reasonable name for the function, if any
+	NameWhat        string      // (n) "global", "local", "method", "field", "upvalue" or ""
+	What            string      // (S) "Lua", "C" (Go function) or "main"
+	Source          string      // (S) source of the chunk that created the function
+	CurrentLine     int         // (l) line about to run, -1 if unknown
+	LineDefined     int         // (S) line where the function definition starts
+	LastLineDefined int         // (S) line where the function definition ends
+	NUps            int         // (u) number of upvalues
+	NParams         int         // (u) number of parameters
+	IsVararg        bool        // (u)
+	IsTailCall      bool        // (t) the function was called by a tail call
+	ShortSrc        string      // (S) printable version of Source
+	CallInfo        interface{} // active function, filled by GetStack
+}
+
+// function called by the VM on debug events, see lua_Hook
+type Hook func(ls LuaState, ar *Debug)
@@ -0,0 +1,26 @@
+package api
+
+import "errors"
+
+// cause of the errors raised once the instruction budget is spent
+var ErrBudgetExhausted = errors.New("budget exhausted")
+
+// LuaError is the error returned by the Go-style API (CallE, DoStringE...)
+// when a chunk cannot be loaded or raises an error while running
+type LuaError struct {
+	Status    ThreadStatus // LUA_ERRRUN, LUA_ERRSYNTAX, LUA_ERRERR...
+	Value     interface{}  // the Lua error object
+	Message   string       // the error object as a string
+	Traceback string       // "stack traceback:..." taken where the error was raised, if any
+	Err       error        // ctx.Err() or ErrBudgetExhausted if the call was interrupted
+}
+
+func (self *LuaError) Error() string {
+	return self.Message
+}
+
+// so that errors.Is(err, context.DeadlineExceeded) or
+// errors.Is(err, ErrBudgetExhausted) tell interrupted calls apart
+func (self *LuaError) Unwrap() error {
+	return self.Err
+}
@@ -0,0 +1,38 @@
+package api
+
+// Ref keeps a Lua value alive in the registry, so that host code can hold
+// on to callbacks and tables between calls
+type Ref struct {
+	ls  LuaState
+	ref int
+}
+
+// NewRef pops the value on the top of the stack of ls and returns a
+// reference to it
+func NewRef(ls LuaState) *Ref {
+	return &Ref{ls: ls, ref: ls.Ref(LUA_REGISTRYINDEX)}
+}
+
+// Push pushes the referenced value onto the stack of ls, which may be any
+// thread of the state the reference was created in; nil is pushed for
+// released references
+func (self *Ref) Push(ls LuaState) {
+	if self.ref == LUA_REFNIL || self.ref == LUA_NOREF {
+		ls.PushNil()
+	} else {
+		ls.RawGetI(LUA_REGISTRYINDEX, int64(self.ref))
+	}
+}
+
+// Release frees the reference, the value may then be collected; releasing
+// twice is harmless
+func (self *Ref) Release() {
+	self.ls.Unref(LUA_REGISTRYINDEX, self.ref)
+	self.ref = LUA_NOREF
+}
+
+// Value returns the reference number in the registry (LUA_REFNIL for nil,
+// LUA_NOREF once released)
+func (self *Ref) Value() int {
+	return self.ref
+}
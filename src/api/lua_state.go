@@ -1,5 +1,10 @@
 package api
 
+import (
+	"context"
+	"unsafe"
+)
+
 type LuaType = int
 type ArithOp = int
 type CompareOp = int
@@ -8,8 +13,8 @@ type ThreadStatus = int
 type GoFunction func(LuaState) int
 
 type LuaState interface {
-	// AuxLib
 	BasicAPI
+	AuxLib
 }
 
 type BasicAPI interface {
@@ -17,6 +22,8 @@ type BasicAPI interface {
 	GetTop() int
 	AbsIndex(idx int) int
 	CheckStack(n int) bool
+	SetStackLimits(maxCalls, maxSlots int)
+	StackLimits() (maxCalls, maxSlots int)
 	Pop(n int)
 	Copy(fromIdx, toIdx int)
 	PushValue(idx int)
@@ -43,6 +50,7 @@ type BasicAPI interface {
 	ToNumberX(idx int) (float64, bool)
 	ToString(idx int) string
 	ToStringX(idx int) (string, bool)
+	StringToNumber(s string) bool
 	// push functions (go -> stack)
 	PushNil()
 	PushBoolean(b bool)
@@ -50,6 +58,7 @@ type BasicAPI interface {
 	PushNumber(f float64)
 	PushString(s string)
 	PushFString(fmt string, a ...interface{}) string
+	PushLightUserData(p unsafe.Pointer)
 	// arithmetic functions
 	Arith(op ArithOp)
 	Compare(idx1, idx2 int, op CompareOp) bool
@@ -67,11 +76,20 @@ type BasicAPI interface {
 	SetI(idx int, i int64)
 	// lua function api
 	Load(chunk []byte, name, mode string) int
+	LoadEnv(chunk []byte, name, mode string, env int) int
 	Call(nArgs, nResults int)
 	// go function api
 	PushGoFunction(f GoFunction)
 	IsGoFunction(idx int) bool
 	ToGoFunction(idx int) GoFunction
+	// userdata
+	NewUserData(data interface{})
+	ToUserData(idx int) interface{}
+	IsUserData(idx int) bool
+	IsLightUserData(idx int) bool
+	ToPointer(idx int) unsafe.Pointer
+	SetUservalue(idx int)
+	GetUservalue(idx int) LuaType
 	// global table api
 	PushGlobalTable()
 	GetGlobal(name string) LuaType
@@ -94,4 +112,40 @@ type BasicAPI interface {
 	// error handling
 	Error() int
 	PCall(nArgs, nRes, msgh int) int
+	// garbage collection
+	GC(what, data int) int
+	MemoryInUse() int64
+	SetMemoryLimit(limit int64)
+	MemoryLimit() int64
+	Close()
+	// coroutine
+	NewThread() LuaState
+	Resume(from LuaState, nArgs int) int
+	Yield(nResults int) int
+	Status() int
+	IsYieldable() bool
+	ToThread(idx int) LuaState
+	PushThread() bool
+	XMove(to LuaState, n int)
+	// debug
+	GetStack(level int, ar *Debug) bool
+	GetInfo(what string, ar *Debug) bool
+	GetLocal(ar *Debug, n int) string
+	SetLocal(ar *Debug, n int) string
+	GetUpvalue(funcIdx, n int) (string, bool)
+	SetUpvalue(funcIdx, n int) (string, bool)
+	UpvalueID(funcIdx, n int) unsafe.Pointer
+	UpvalueJoin(funcIdx1, n1, funcIdx2, n2 int)
+	SetHook(f Hook, mask, count int)
+	GetHook() Hook
+	GetHookMask() int
+	GetHookCount() int
+	// host facilities
+	SetDeterministic(seed int64)
+	IsDeterministic() bool
+	SetContext(ctx context.Context)
+	Context() context.Context
+	SetBudget(budget int64)
+	Budget() int64
+	SetOpWeight(op int, weight int64)
 }
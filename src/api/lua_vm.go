@@ -11,4 +11,5 @@ type LuaVM interface {
 	RegisterCount() int
 	LoadVararg(n int)
 	CloseUpvalues(a int)
+	TailCall(nArgs int) bool
 }
@@ -40,25 +40,35 @@ func main2() {
 
 func main() {
 	if len(os.Args) > 1 {
-		data, err := ioutil.ReadFile(os.Args[1])
-		if err != nil {
-			panic(err)
-		}
 		ls := state.New()
-		ls.Register("print", _print)
-		ls.Register("getmetatable", getMetatable)
-		ls.Register("setmetatable", setMetatable)
-		ls.Register("next", next)
-		ls.Register("pairs", pairs)
-		ls.Register("ipairs", iPairs)
-		ls.Register("pcall", pCall)
-		ls.Register("error", _error)
-		ls.Register("type", _type)
-		ls.Load(data, os.Args[1], "t")
-		ls.Call(0, 0)
+		ls.OpenLibs()
+		status := ls.LoadFile(os.Args[1])
+		if status == api.LUA_OK {
+			ls.PushGoFunction(msgHandler)
+			ls.Insert(1)
+			status = ls.PCall(0, 0, 1)
+		}
+		if status != api.LUA_OK {
+			fmt.Fprintf(os.Stderr, "lua: %s\n", ls.ToString(-1))
+			os.Exit(1)
+		}
 	}
 }
 
+// lua-5.3.4/src/lua.c#msghandler
+// adds a traceback to error messages of the script
+func msgHandler(ls api.LuaState) int {
+	msg, ok := ls.ToStringX(1)
+	if !ok { // is error object not a string?
+		if ls.CallMeta(1, "__tostring") && ls.Type(-1) == api.LUA_TSTRING {
+			return 1 // that is the message
+		}
+		msg = fmt.Sprintf("(error object is a %s value)", ls.TypeName2(1))
+	}
+	ls.Traceback(ls, msg, 1) // append a standard traceback
+	return 1
+}
+
 func testParser(chunk, chunkName string) {
 	ast := parser.Parse(chunk, chunkName)
 	d, err := json.MarshalIndent(ast, "", "\t")
@@ -72,87 +82,6 @@ func testParser(chunk, chunkName string) {
 	fmt.Println(string(d))
 }
 
-func pCall(ls api.LuaState) int {
-	nArgs := ls.GetTop() - 1
-	status := ls.PCall(nArgs, -1, 0)
-	ls.PushBoolean(status == api.LUA_OK)
-	ls.Insert(1)
-	return ls.GetTop()
-}
-
-func _error(ls api.LuaState) int {
-	return ls.Error()
-}
-
-func iPairs(ls api.LuaState) int {
-	aux := func(ls api.LuaState) int {
-		i := ls.ToInteger(2) + 1
-		ls.PushInteger(i)
-		if ls.GetI(1, i) == api.LUA_TNIL {
-			return 1
-		} else {
-			return 2
-		}
-	}
-	ls.PushGoFunction(aux)
-	ls.PushValue(1)
-	ls.PushInteger(0)
-	return 3
-}
-
-func pairs(ls api.LuaState) int {
-	ls.PushGoFunction(next)
-	ls.PushValue(1)
-	ls.PushNil()
-	return 3
-}
-
-func next(ls api.LuaState) int {
-	ls.SetTop(2)
-	if ls.Next(1) {
-		return 2
-	} else {
-		ls.PushNil()
-		return 1
-	}
-}
-
-func getMetatable(ls api.LuaState) int {
-	if !ls.GetMetatable(1) {
-		ls.PushNil()
-	}
-	return 1
-}
-
-func setMetatable(ls api.LuaState) int {
-	ls.SetMetatable(1)
-	return 1
-}
-
-func _type(ls api.LuaState) int {
-	t := ls.Type(1)
-	ls.PushString(ls.TypeName(t))
-	return 1
-}
-
-func _print(ls api.LuaState) int {
-	nArgs := ls.GetTop()
-	for i := 1; i <= nArgs; i++ {
-		if ls.IsBoolean(i) {
-			fmt.Printf("%t", ls.ToBoolean(i))
-		} else if ls.IsString(i) {
-			fmt.Print(ls.ToString(i))
-		} else {
-			fmt.Print(ls.TypeName(ls.Type(i)))
-		}
-		if i < nArgs {
-			fmt.Print("\t")
-		}
-	}
-	fmt.Println()
-	return 0
-}
-
 //func luaMain(proto *binchunk.Prototype) {
 //	nRegs := int(proto.MaxStackSize)
 //	ls := state.New(nRegs+8, proto)
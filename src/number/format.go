@@ -0,0 +1,34 @@
+package number
+
+import (
+	"math"
+	"strconv"
+	"strings"
+)
+
+// lua-5.3.4/src/lobject.c#tostringbuff
+func FormatInteger(i int64) string {
+	return strconv.FormatInt(i, 10)
+}
+
+// lua-5.3.4/src/lobject.c#tostringbuff
+// formats f like C's "%.14g" (LUAI_NUMFFORMAT), adding ".0" to values that
+// would look like integers
+func FormatFloat(f float64) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "inf"
+	case math.IsInf(f, -1):
+		return "-inf"
+	case math.IsNaN(f): // printf shows the sign of NaNs too
+		if math.Signbit(f) {
+			return "-nan"
+		}
+		return "nan"
+	}
+	s := strconv.FormatFloat(f, 'g', 14, 64)
+	if strings.Trim(s, "-0123456789") == "" { // looks like an int?
+		s += ".0" // adds '.0' to result
+	}
+	return s
+}
@@ -0,0 +1,40 @@
+package number
+
+import (
+	"math"
+	"testing"
+)
+
+func TestFormatFloat(t *testing.T) {
+	cases := []struct {
+		f   float64
+		str string
+	}{
+		{0, "0.0"},
+		{math.Copysign(0, -1), "-0.0"},
+		{100.0, "100.0"},
+		{-2.5, "-2.5"},
+		{1e15, "1e+15"},
+		{1e14, "1e+14"},
+		{123456789012345, "1.2345678901234e+14"},
+		{1e-5, "1e-05"},
+		{0.1, "0.1"},
+		{math.Pi, "3.1415926535898"},
+		{math.Inf(1), "inf"},
+		{math.Inf(-1), "-inf"},
+		{math.NaN(), "nan"},
+	}
+	for _, c := range cases {
+		if s := FormatFloat(c.f); s != c.str {
+			t.Errorf("FormatFloat(%g) = %q, want %q", c.f, s, c.str)
+		}
+	}
+}
+
+func TestFormatInteger(t *testing.T) {
+	for i, s := range map[int64]string{0: "0", -7: "-7", math.MinInt64: "-9223372036854775808"} {
+		if FormatInteger(i) != s {
+			t.Errorf("FormatInteger(%d) = %q, want %q", i, FormatInteger(i), s)
+		}
+	}
+}
@@ -2,40 +2,66 @@ package number
 
 import "math"
 
+// lua-5.3.4/src/lvm.c#luaV_mod
+// the caller must rule out b == 0
 func IMod(a, b int64) int64 {
-	return a - IFloorDiv(a, b)*b
+	if b == -1 {
+		return 0 // avoid overflow with 0x80000...%-1
+	}
+	r := a % b
+	if r != 0 && (a^b) < 0 { // 'a/b' would be non-integer negative?
+		r += b // correct result for different rounding
+	}
+	return r
 }
 
+// lua-5.3.4/src/llimits.h#luai_nummod
 func FMod(a, b float64) float64 {
-	return a - math.Floor(a/b)*b
+	m := math.Mod(a, b)
+	if m*b < 0 {
+		m += b
+	}
+	return m
 }
 
 func FFloorDiv(a, b float64) float64 {
 	return math.Floor(a / b)
 }
 
+// lua-5.3.4/src/lvm.c#luaV_shiftl
 func ShiftLeft(a, n int64) int64 {
-	if n >= 0 {
-		return a << uint64(n)
+	if n <= -64 {
+		return 0
+	} else if n < 0 { // shift right
+		return int64(uint64(a) >> uint64(-n))
 	}
-	return ShiftRight(a, -n)
+	return int64(uint64(a) << uint64(n)) // 0 for n >= 64
 }
 
 func ShiftRight(a, n int64) int64 {
-	if n >= 0 {
-		return int64(uint64(a) >> uint64(n))
-	}
-	return ShiftLeft(a, -n)
+	return ShiftLeft(a, 0-n) // wraps around for mininteger, giving 0
 }
 
+// lua-5.3.4/src/lvm.c#luaV_div
+// the caller must rule out b == 0
 func IFloorDiv(a, b int64) int64 {
-	if a > 0 && b > 0 || a < 0 && b < 0 || a%b == 0 {
-		return a / b
+	if b == -1 {
+		return 0 - a // avoid overflow with 0x80000.../-1
+	}
+	q := a / b                 // perform C division
+	if (a^b) < 0 && a%b != 0 { // 'a/b' would be negative non-integer?
+		q -= 1 // correct result for different rounding
 	}
-	return a/b - 1
+	return q
 }
 
+// lua-5.3.4/src/luaconf.h#lua_numbertointeger
+// converts f to an integer if it has an exact representation
 func FloatToInteger(f float64) (int64, bool) {
-	i := int64(f)
-	return i, float64(i) == f
+	// -2^63 <= f < 2^63, false for NaN
+	if f >= math.MinInt64 && f < -math.MinInt64 {
+		i := int64(f)
+		return i, float64(i) == f
+	}
+	return 0, false
 }
@@ -1,15 +1,214 @@
 package number
 
 import (
+	"math"
 	"strconv"
+	"strings"
 )
 
+// maximum number of significant digits to read (to avoid overflows even
+// with single floats)
+const MAXSIGDIG = 30
+
+// lua-5.3.4/src/lobject.c#l_str2int
+// parses a decimal or hexadecimal integer, surrounded by optional spaces;
+// hexadecimals wrap around, decimals that do not fit are not accepted
 func ParseInteger(str string) (int64, bool) {
-	i, err := strconv.ParseInt(str, 10, 64)
-	return i, err == nil
+	s := skipSpaces(str, 0)
+	s, neg := isNeg(str, s)
+	var a uint64
+	empty := true
+	if hasHexPrefix(str[s:]) { // hex?
+		for s += 2; s < len(str) && isXDigit(str[s]); s++ { // skip '0x'
+			a = a*16 + uint64(hexaValue(str[s]))
+			empty = false
+		}
+	} else { // decimal
+		const maxBy10 = uint64(math.MaxInt64 / 10)
+		const maxLastD = uint64(math.MaxInt64 % 10)
+		for ; s < len(str) && isDigit(str[s]); s++ {
+			d := uint64(str[s] - '0')
+			if a >= maxBy10 && (a > maxBy10 || d > maxLastD+neg) { // overflow?
+				return 0, false // do not accept it (as integer)
+			}
+			a = a*10 + d
+			empty = false
+		}
+	}
+	s = skipSpaces(str, s) // skip trailing spaces
+	if empty || s != len(str) {
+		return 0, false // something wrong in the numeral
+	}
+	if neg == 1 {
+		return int64(0 - a), true
+	}
+	return int64(a), true
 }
 
+// lua-5.3.4/src/lobject.c#l_str2d
+// parses a decimal or hexadecimal float, surrounded by optional spaces;
+// 'inf' and 'nan' are rejected
 func ParseFloat(str string) (float64, bool) {
-	f, err := strconv.ParseFloat(str, 64)
-	return f, err == nil
+	if strings.ContainsAny(str, "nN") { // reject 'inf' and 'nan'
+		return 0, false
+	}
+	var f float64
+	var end int
+	if strings.ContainsAny(str, "xX") { // hex?
+		f, end = strx2number(str)
+	} else {
+		f, end = str2number(str)
+	}
+	if end == 0 || skipSpaces(str, end) != len(str) {
+		return 0, false // nothing recognized or invalid trailing characters
+	}
+	return f, true
+}
+
+// lua-5.3.4/src/luaconf.h#lua_str2number
+// reads the longest prefix of str accepted by C strtod, returns the value
+// and the end of that prefix (0 if there is none)
+func str2number(str string) (float64, int) {
+	start := skipSpaces(str, 0)
+	s := start
+	if s < len(str) && (str[s] == '-' || str[s] == '+') {
+		s++
+	}
+	digits := 0
+	for ; s < len(str) && isDigit(str[s]); s++ {
+		digits++
+	}
+	if s < len(str) && str[s] == '.' {
+		for s++; s < len(str) && isDigit(str[s]); s++ {
+			digits++
+		}
+	}
+	if digits == 0 {
+		return 0, 0
+	}
+	if s < len(str) && (str[s] == 'e' || str[s] == 'E') { // exponent part?
+		e := s + 1
+		if e < len(str) && (str[e] == '-' || str[e] == '+') {
+			e++
+		}
+		if e < len(str) && isDigit(str[e]) {
+			for s = e; s < len(str) && isDigit(str[s]); s++ {
+			}
+		}
+	}
+	// out of range values become inf or 0, like strtod does
+	f, err := strconv.ParseFloat(str[start:s], 64)
+	if err != nil && err.(*strconv.NumError).Err != strconv.ErrRange {
+		return 0, 0
+	}
+	return f, s
+}
+
+// lua-5.3.4/src/lobject.c#lua_strx2number
+// converts an hexadecimal numeric string to a number, following C99
+// specification for 'strtod'
+func strx2number(str string) (float64, int) {
+	r := 0.0      // result (accumulator)
+	sigdig := 0   // number of significant digits
+	nosigdig := 0 // number of non-significant digits
+	e := 0        // exponent correction
+	hasdot := false
+	s := skipSpaces(str, 0)     // skip initial spaces
+	s, neg := isNeg(str, s)     // check signal
+	if !hasHexPrefix(str[s:]) { // check '0x'
+		return 0, 0 // invalid format (no '0x')
+	}
+	for s += 2; s < len(str); s++ { // skip '0x' and read numeral
+		if c := str[s]; c == '.' {
+			if hasdot {
+				break // second dot? stop loop
+			}
+			hasdot = true
+		} else if isXDigit(c) {
+			if sigdig == 0 && c == '0' { // non-significant digit (zero)?
+				nosigdig++
+			} else if sigdig++; sigdig <= MAXSIGDIG { // can read it without overflow?
+				r = r*16 + float64(hexaValue(c))
+			} else {
+				e++ // too many digits; ignore, but still count for exponent
+			}
+			if hasdot {
+				e-- // decimal digit? correct exponent
+			}
+		} else {
+			break // neither a dot nor a digit
+		}
+	}
+	if nosigdig+sigdig == 0 { // no digits?
+		return 0, 0 // invalid format
+	}
+	end := s                                              // valid up to here
+	e *= 4                                                // each digit multiplies/divides value by 2^4
+	if s < len(str) && (str[s] == 'p' || str[s] == 'P') { // exponent part?
+		exp1 := 0 // exponent value
+		var neg1 uint64
+		s, neg1 = isNeg(str, s+1)            // skip 'p', signal
+		if s < len(str) && isDigit(str[s]) { // must have at least one digit
+			for ; s < len(str) && isDigit(str[s]); s++ {
+				if exp1 < 1<<20 { // larger ones give inf or 0 anyway
+					exp1 = exp1*10 + int(str[s]-'0')
+				}
+			}
+			if neg1 == 1 {
+				exp1 = -exp1
+			}
+			e += exp1
+			end = s // valid up to here
+		}
+	}
+	if neg == 1 {
+		r = -r
+	}
+	return math.Ldexp(r, e), end
+}
+
+// lua-5.3.4/src/lobject.c#isneg
+// skips an optional sign, returns 1 if it is a '-'
+func isNeg(str string, s int) (int, uint64) {
+	if s < len(str) {
+		if str[s] == '-' {
+			return s + 1, 1
+		} else if str[s] == '+' {
+			return s + 1, 0
+		}
+	}
+	return s, 0
+}
+
+func hasHexPrefix(s string) bool {
+	return len(s) >= 2 && s[0] == '0' && (s[1] == 'x' || s[1] == 'X')
+}
+
+func skipSpaces(str string, s int) int {
+	for s < len(str) && isSpace(str[s]) {
+		s++
+	}
+	return s
+}
+
+// character classes of the "C" locale
+
+func isSpace(c byte) bool {
+	return c == ' ' || '\t' <= c && c <= '\r'
+}
+
+func isDigit(c byte) bool {
+	return '0' <= c && c <= '9'
+}
+
+func isXDigit(c byte) bool {
+	return isDigit(c) || 'a' <= c && c <= 'f' || 'A' <= c && c <= 'F'
+}
+
+// lua-5.3.4/src/lobject.c#luaO_hexavalue
+func hexaValue(c byte) int {
+	if isDigit(c) {
+		return int(c - '0')
+	}
+	return int(c|('a'-'A')) - 'a' + 10
 }
@@ -0,0 +1,88 @@
+package number
+
+import (
+	"math"
+	"testing"
+)
+
+func TestParseInteger(t *testing.T) {
+	cases := []struct {
+		str string
+		i   int64
+		ok  bool
+	}{
+		{"0", 0, true},
+		{" 42 ", 42, true},
+		{"-42", -42, true},
+		{"9223372036854775807", math.MaxInt64, true},
+		{"-9223372036854775808", math.MinInt64, true},
+		{"9223372036854775808", 0, false}, // does not fit, a float
+		{"0x10", 16, true},
+		{"-0XfF", -255, true},
+		{"0xffffffffffffffff", -1, true}, // hexadecimals wrap around
+		{"0x", 0, false},
+		{"", 0, false},
+		{"-", 0, false},
+		{"1.0", 0, false},
+		{"1e2", 0, false},
+		{"12a", 0, false},
+	}
+	for _, c := range cases {
+		if i, ok := ParseInteger(c.str); i != c.i || ok != c.ok {
+			t.Errorf("ParseInteger(%q) = %d, %v; want %d, %v", c.str, i, ok, c.i, c.ok)
+		}
+	}
+}
+
+func TestParseFloat(t *testing.T) {
+	cases := []struct {
+		str string
+		f   float64
+		ok  bool
+	}{
+		{"1.5", 1.5, true},
+		{" .5 ", 0.5, true},
+		{"5.", 5, true},
+		{"-1e2", -100, true},
+		{"1E+2", 100, true},
+		{"2e-1", 0.2, true},
+		{"1e400", math.Inf(1), true}, // out of range, like strtod
+		{"0x10", 16, true},
+		{"0x.8", 0.5, true},
+		{"0xA.8p1", 21, true},
+		{"-0x1p-2", -0.25, true},
+		{"0x1P+4", 16, true},
+		{"0x1p", 0, false},
+		{"0x", 0, false},
+		{"0x.", 0, false},
+		{"1e", 0, false},
+		{"1e+", 0, false},
+		{".", 0, false},
+		{"", 0, false},
+		{"1.5x", 0, false},
+		{"inf", 0, false},
+		{"-INF", 0, false},
+		{"nan", 0, false},
+		{"NaN", 0, false},
+		{"1n", 0, false},
+	}
+	for _, c := range cases {
+		if f, ok := ParseFloat(c.str); f != c.f || ok != c.ok {
+			t.Errorf("ParseFloat(%q) = %g, %v; want %g, %v", c.str, f, ok, c.f, c.ok)
+		}
+	}
+}
+
+// what is formatted reads back as the same number
+func TestRoundTrip(t *testing.T) {
+	for _, i := range []int64{0, -1, 1 << 53, math.MaxInt64, math.MinInt64} {
+		if j, ok := ParseInteger(FormatInteger(i)); !ok || j != i {
+			t.Errorf("%d reads back as %d, %v", i, j, ok)
+		}
+	}
+	for _, f := range []float64{0, 1, -2.5, 0.1, 1e15, 1e-5, 100, 1e100, 123456.789} {
+		if g, ok := ParseFloat(FormatFloat(f)); !ok || g != f {
+			t.Errorf("%g reads back as %g, %v", f, g, ok)
+		}
+	}
+}
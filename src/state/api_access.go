@@ -2,7 +2,8 @@ package state
 
 import (
 	"api"
-	"fmt"
+	"number"
+	"unsafe"
 )
 
 func (self *luaState) Type(idx int) api.LuaType {
@@ -57,8 +58,12 @@ func (self *luaState) ToStringX(idx int) (string, bool) {
 	switch x := val.(type) {
 	case string:
 		return x, true
-	case int64, float64:
-		s := fmt.Sprintf("%v", x)
+	case int64:
+		s := number.FormatInteger(x)
+		self.stack.set(idx, s) // luaO_tostring converts in place
+		return s, true
+	case float64:
+		s := number.FormatFloat(x)
 		self.stack.set(idx, s)
 		return s, true
 	default:
@@ -66,6 +71,18 @@ func (self *luaState) ToStringX(idx int) (string, bool) {
 	}
 }
 
+func (self *luaState) StringToNumber(s string) bool {
+	if n, ok := number.ParseInteger(s); ok {
+		self.PushInteger(n)
+		return true
+	}
+	if n, ok := number.ParseFloat(s); ok {
+		self.PushNumber(n)
+		return true
+	}
+	return false
+}
+
 func (self *luaState) IsString(idx int) bool {
 	t := self.Type(idx)
 	return t == api.LUA_TSTRING || t == api.LUA_TNUMBER
@@ -99,6 +116,44 @@ func (self *luaState) IsGoFunction(idx int) bool {
 	return self.ToGoFunction(idx) != nil
 }
 
+func (self *luaState) ToUserData(idx int) interface{} {
+	val := self.stack.get(idx)
+	switch x := val.(type) {
+	case *userdata:
+		return x.data
+	case lightUserdata:
+		return unsafe.Pointer(x)
+	}
+	return nil
+}
+
+func (self *luaState) IsUserData(idx int) bool {
+	t := self.Type(idx)
+	return t == api.LUA_TUSERDATA || t == api.LUA_TLIGHTUSERDATA
+}
+
+func (self *luaState) IsLightUserData(idx int) bool {
+	return self.Type(idx) == api.LUA_TLIGHTUSERDATA
+}
+
+func (self *luaState) ToPointer(idx int) unsafe.Pointer {
+	val := self.stack.get(idx)
+	switch x := val.(type) {
+	case *luaTable:
+		return unsafe.Pointer(x)
+	case *closure:
+		return unsafe.Pointer(x)
+	case *luaState:
+		return unsafe.Pointer(x)
+	case *userdata:
+		return unsafe.Pointer(x)
+	case lightUserdata:
+		return unsafe.Pointer(x)
+	default:
+		return nil
+	}
+}
+
 func (self *luaState) TypeName(tp api.LuaType) string {
 	switch tp {
 	case api.LUA_TNONE:
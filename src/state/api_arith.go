@@ -61,6 +61,9 @@ func (self *luaState) Arith(op api.ArithOp) {
 		a = b
 	}
 	operator := operators[op]
+	if op == api.LUA_OPIDIV || op == api.LUA_OPMOD {
+		self.checkDivByZero(a, b, op)
+	}
 	if result := arith(a, b, operator); result != nil {
 		self.stack.push(result)
 		return
@@ -71,7 +74,33 @@ func (self *luaState) Arith(op api.ArithOp) {
 		self.stack.push(res)
 		return
 	}
-	panic("arith error")
+
+	switch op {
+	case api.LUA_OPBAND, api.LUA_OPBOR, api.LUA_OPBXOR,
+		api.LUA_OPSHL, api.LUA_OPSHR, api.LUA_OPBNOT:
+		_, okA := convertToFloat(a)
+		_, okB := convertToFloat(b)
+		if okA && okB { // numbers (or strings convertible to numbers)
+			self.toIntError(a, b)
+		} else {
+			self.opIntError(a, b, "perform bitwise operation on")
+		}
+	default:
+		self.opIntError(a, b, "perform arithmetic on")
+	}
+}
+
+// lua-5.3.4/src/lvm.c#luaV_div
+// integer division and modulo by zero are errors, unlike the float ones
+func (self *luaState) checkDivByZero(a, b luaValue, op api.ArithOp) {
+	_, intA := a.(int64)
+	if y, intB := b.(int64); intA && intB && y == 0 {
+		if op == api.LUA_OPIDIV {
+			self.runError("attempt to perform 'n//0'")
+		} else {
+			self.runError("attempt to perform 'n%%0'")
+		}
+	}
 }
 
 func arith(a, b luaValue, op operator) luaValue {
@@ -0,0 +1,24 @@
+package state
+
+import (
+	"api"
+	"testing"
+)
+
+func TestDivisionByZeroMessages(t *testing.T) {
+	cases := map[string]string{
+		"return 1 // 0": "attempt to perform 'n//0'",
+		"return 1 % 0":  "attempt to perform 'n%0'",
+	}
+	for chunk, want := range cases {
+		ls := New()
+		ls.OpenLibs()
+		err := ls.DoStringE(chunk)
+		if err == nil {
+			t.Fatalf("%s: no error", chunk)
+		}
+		if msg := err.(*api.LuaError).Message; msg != `[string "`+chunk+`"]:1: `+want {
+			t.Errorf("%s: got %q, want %q", chunk, msg, want)
+		}
+	}
+}
@@ -4,32 +4,117 @@ import (
 	"api"
 	"binchunk"
 	"compiler"
+	"strings"
 	"vm"
 )
 
+// [-0, +1, –]
+// http://www.lua.org/manual/5.3/manual.html#lua_load
 func (self *luaState) Load(chunk []byte, name, mode string) int {
-	var proto *binchunk.Prototype
-	if binchunk.IsBinaryChunk(chunk) {
-		if mode != "b" {
-			panic("Source mode is not 'b'")
-		}
-		proto = binchunk.Undump(chunk)
+	return self.LoadEnv(chunk, name, mode, 0)
+}
+
+// [-0, +1, –]
+// like Load, but the first upvalue of the loaded function (its _ENV) is set
+// to the value at index env instead of the global table, unless env is 0
+func (self *luaState) LoadEnv(chunk []byte, name, mode string, env int) int {
+	var envVal luaValue
+	if env != 0 {
+		envVal = self.stack.get(env)
 	} else {
-		if mode != "t" {
-			panic("Source mode is not 't'")
-		}
-		proto = compiler.Compile(string(chunk), name)
+		envVal = self.registry.get(api.LUA_RIDX_GLOBALS)
+	}
+	isBinary := binchunk.IsBinaryChunk(chunk)
+	if isBinary && !strings.Contains(mode, "b") {
+		return self.loadError("attempt to load a binary chunk (mode is '%s')", mode)
+	}
+	if !isBinary && !strings.Contains(mode, "t") {
+		return self.loadError("attempt to load a text chunk (mode is '%s')", mode)
+	}
+	proto, err := loadProto(chunk, name, isBinary)
+	if err != nil {
+		return self.loadError("%v", err)
+	}
+	if !self.tryAllocate(sizeClosure + len(proto.Upvalues)*sizeUpvalue) {
+		self.stack.push("not enough memory")
+		return api.LUA_ERRMEM
 	}
 	c := newLuaClosure(proto)
 	self.stack.push(c)
 	if len(proto.Upvalues) > 0 {
-		env := self.registry.get(api.LUA_RIDX_GLOBALS)
-		c.upvals[0] = &upvalue{&env}
+		c.upvals[0] = &upvalue{&envVal}
+	}
+	return api.LUA_OK
+}
+
+// undumps or compiles a chunk, the compiler reports errors by panicking
+func loadProto(chunk []byte, name string, isBinary bool) (proto *binchunk.Prototype, err interface{}) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = r
+			// lexer and parser messages start with the raw chunk name
+			if msg, ok := r.(string); ok && strings.HasPrefix(msg, name+":") {
+				err = chunkID(name) + msg[len(name):]
+			}
+		}
+	}()
+	if isBinary {
+		return binchunk.Undump(chunk), nil
 	}
-	return 0
+	return compiler.Compile(string(chunk), name), nil
+}
+
+// pushes the message and returns LUA_ERRSYNTAX
+func (self *luaState) loadError(format string, a ...interface{}) int {
+	self.PushFString(format, a...)
+	return api.LUA_ERRSYNTAX
 }
 
 func (self *luaState) Call(nArgs, nResults int) {
+	c, nArgs := self.getCallable(nArgs)
+	if c.proto != nil {
+		self.callLuaClosure(nArgs, nResults, c)
+		//fmt.Printf("call lua closure: %s<%d, %d>\n", c.proto.Source, c.proto.LineDefined, c.proto.LastLineDefined)
+	} else {
+		self.callGoClosure(nArgs, nResults, c)
+	}
+}
+
+// calls like Call(nArgs, LUA_MULTRET), but if the callee is a Lua function
+// it replaces the running one in its frame (proper tail call) and true is
+// returned: the callee then goes on running in the current frame
+func (self *luaState) TailCall(nArgs int) bool {
+	c, nArgs := self.getCallable(nArgs)
+	if c.proto == nil {
+		self.callGoClosure(nArgs, api.LUA_MULTRET, c)
+		return false
+	}
+	funcAndArgs := self.stack.popN(nArgs + 1)
+	self.CloseUpvalues(1) // the replaced function's locals go away
+	frame := self.stack
+	if size := int(c.proto.MaxStackSize) + api.LUA_MINSATCK; len(frame.slots) < size {
+		self.checkStackLimits(0, size-len(frame.slots))
+		self.nSlots += size - len(frame.slots)
+		frame.slots = make([]luaValue, size)
+	} else {
+		for i := range frame.slots {
+			frame.slots[i] = nil
+		}
+	}
+	frame.top, frame.pc = 0, 0
+	frame.varargs = nil
+	frame.isTailCall = true
+	frame.initLuaFrame(c, funcAndArgs)
+	if self.hookMask&api.LUA_MASKCALL != 0 {
+		self.callHook(api.LUA_HOOKTAILCALL, -1)
+	}
+	return true
+}
+
+// lua-5.3.4/src/ldo.c#tryfuncTM
+// returns the closure to call for the value below the arguments; for other
+// values the __call metamethod is used, with the value as first argument
+func (self *luaState) getCallable(nArgs int) (*closure, int) {
 	val := self.stack.get(-(nArgs + 1))
 	c, ok := val.(*closure)
 	if !ok {
@@ -37,23 +122,32 @@ func (self *luaState) Call(nArgs, nResults int) {
 			if c, ok = mf.(*closure); ok {
 				self.stack.push(val)
 				self.Insert(-(nArgs + 2))
+				self.stack.set(-(nArgs + 2), c)
 				nArgs++
 			}
 		}
 	}
-	if ok {
-		if c.proto != nil {
-			self.callLuaClosure(nArgs, nResults, c)
-			//fmt.Printf("call lua closure: %s<%d, %d>\n", c.proto.Source, c.proto.LineDefined, c.proto.LastLineDefined)
-		} else {
-			self.callGoClosure(nArgs, nResults, c)
-		}
+	if !ok {
+		self.runTypeError(val, 0, "call")
 	}
+	return c, nArgs
 }
 
 func (self *luaState) runLuaClosure() {
+	g := self.global
 	for {
+		if g.ctxDone != nil {
+			if g.ctxCountdown--; g.ctxCountdown <= 0 {
+				self.checkContext()
+			}
+		}
 		inst := vm.Instruction(self.Fetch())
+		if g.metering {
+			self.charge(inst.OpCode())
+		}
+		if self.hookMask&(api.LUA_MASKLINE|api.LUA_MASKCOUNT) != 0 {
+			self.traceExec()
+		}
 		inst.Execute(self)
 		if inst.OpCode() == vm.OP_RETURN {
 			break
@@ -62,13 +156,21 @@ func (self *luaState) runLuaClosure() {
 }
 
 func (self *luaState) callGoClosure(nArgs, nResults int, c *closure) {
+	self.checkGC()
+	self.checkStackLimits(1, nArgs+api.LUA_MINSATCK)
 	newStack := newLuaStack(nArgs+api.LUA_MINSATCK, self)
 	newStack.closure = c
 	args := self.stack.popN(nArgs)
 	newStack.pushN(args, nArgs)
 	self.stack.pop()
 	self.pushLuaStack(newStack)
+	if self.hookMask&api.LUA_MASKCALL != 0 {
+		self.callHook(api.LUA_HOOKCALL, -1)
+	}
 	goResNum := c.goFunc(self)
+	if self.hookMask&(api.LUA_MASKRET|api.LUA_MASKLINE) != 0 {
+		self.retHook()
+	}
 	self.popLuaStack()
 
 	if nResults != 0 {
@@ -79,25 +181,39 @@ func (self *luaState) callGoClosure(nArgs, nResults int, c *closure) {
 }
 
 func (self *luaState) callLuaClosure(nArgs, nResults int, c *closure) {
-	nRegs := int(c.proto.MaxStackSize)
-	nParams := int(c.proto.NumParams)
-	isVararg := c.proto.IsVararg == 1
-	newStack := newLuaStack(nRegs+api.LUA_MINSATCK, self)
-	newStack.closure = c
-
-	funcAndArgs := self.stack.popN(nArgs + 1)
-	newStack.pushN(funcAndArgs[1:], nParams)
-	newStack.top = nRegs
-	if nArgs > nParams && isVararg {
-		newStack.varargs = funcAndArgs[nParams+1:]
-	}
+	self.checkGC()
+	size := int(c.proto.MaxStackSize) + api.LUA_MINSATCK
+	self.checkStackLimits(1, size)
+	newStack := newLuaStack(size, self)
+	newStack.initLuaFrame(c, self.stack.popN(nArgs+1))
 
 	self.pushLuaStack(newStack)
+	if self.hookMask&api.LUA_MASKCALL != 0 {
+		self.callHook(api.LUA_HOOKCALL, -1)
+	}
 	self.runLuaClosure()
+	if self.hookMask&(api.LUA_MASKRET|api.LUA_MASKLINE) != 0 {
+		self.retHook()
+	}
 	self.popLuaStack()
 	if nResults != 0 {
+		nRegs := int(newStack.closure.proto.MaxStackSize) // closure may have been replaced by tail calls
 		results := newStack.popN(newStack.top - nRegs)
 		self.stack.check(len(results))
 		self.stack.pushN(results, nResults)
 	}
 }
+
+// moves the arguments of Lua closure c into their registers, the
+// extra ones become varargs
+func (self *luaStack) initLuaFrame(c *closure, funcAndArgs []luaValue) {
+	nRegs := int(c.proto.MaxStackSize)
+	nParams := int(c.proto.NumParams)
+	isVararg := c.proto.IsVararg == 1
+	self.closure = c
+	self.pushN(funcAndArgs[1:], nParams)
+	self.top = nRegs
+	if nArgs := len(funcAndArgs) - 1; nArgs > nParams && isVararg {
+		self.varargs = funcAndArgs[nParams+1:]
+	}
+}
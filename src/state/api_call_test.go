@@ -0,0 +1,55 @@
+package state
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const sandboxed = `x = 2; y = 3; return x`
+
+func TestLoadWithEnvironment(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "chunk.lua")
+	if err := os.WriteFile(file, []byte(sandboxed), 0644); err != nil {
+		t.Fatal(err)
+	}
+	ls := New()
+	ls.OpenLibs()
+	ls.PushString(file)
+	ls.SetGlobal("file")
+	err := ls.DoStringE(`
+		x = 1
+		for _, loader in ipairs{
+			function(env) return load("` + sandboxed + `", "chunk", "t", env) end,
+			function(env) return loadfile(file, "t", env) end,
+		} do
+			local env = {}
+			local f = assert(loader(env))
+			assert(f() == 2, "result")
+			assert(x == 1 and y == nil, "globals changed")
+			assert(env.x == 2 and env.y == 3, "environment not used")
+			-- an explicit nil environment is an environment too
+			assert(not pcall(loader(nil)))
+		end`)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLoadEnv(t *testing.T) {
+	ls := New()
+	ls.OpenLibs()
+	ls.NewTable()
+	if status := ls.LoadEnv([]byte(sandboxed), "chunk", "t", 1); status != 0 {
+		t.Fatal(ls.ToString(-1))
+	}
+	ls.Call(0, 0)
+	ls.GetField(1, "y")
+	if ls.ToInteger(-1) != 3 {
+		t.Fatal("environment not used")
+	}
+	ls.GetGlobal("y")
+	if !ls.IsNil(-1) {
+		t.Fatal("global table changed")
+	}
+}
@@ -1,6 +1,10 @@
 package state
 
-import "api"
+import (
+	"api"
+	"math"
+	"number"
+)
 
 func (self *luaState) RawEqual(idx1, idx2 int) bool {
 	if !self.stack.isValid(idx1) || !self.stack.isValid(idx2) {
@@ -40,36 +44,41 @@ func _le(a, b luaValue, ls *luaState) bool {
 		case int64:
 			return x <= y
 		case float64:
-			return float64(x) <= y
+			return leIntFloat(x, y)
 		}
 	case float64:
 		switch y := b.(type) {
-		case int64:
-			return x <= float64(y)
+		case int64: // without NaN, (a <= b) is not (b < a)
+			return !math.IsNaN(x) && !ltIntFloat(y, x)
 		case float64:
 			return x <= y
 		}
 	}
 	if result, ok := callMetamethod(a, b, "__le", ls); ok {
 		return convertToBoolean(result)
-	} else if result, ok := callMetamethod(a, b, "__lt", ls); ok {
-		return convertToBoolean(result)
+	} else if result, ok := callMetamethod(b, a, "__lt", ls); ok {
+		return !convertToBoolean(result) // a <= b is not (b < a)
 	}
 
-	panic("comparision error")
+	ls.orderError(a, b)
+	return false
 }
 
 func _eq(a, b luaValue, ls *luaState) bool {
 	switch x := a.(type) {
 	case nil:
 		return b == nil
-	case *luaTable:
-		if y, ok := b.(*luaTable); ok && x != y && ls != nil {
-			if result, ok := callMetamethod(x, y, "__eq", ls); ok {
-				return convertToBoolean(result)
-			}
+	case *luaTable, *userdata:
+		if a == b {
+			return true
+		} else if ls == nil || typeOf(a) != typeOf(b) {
+			return false // raw equality, or values of different types
 		}
-		return a == b
+		// lua-5.3.4/src/lvm.c#luaV_equalobj
+		if result, ok := callMetamethod(a, b, "__eq", ls); ok {
+			return convertToBoolean(result)
+		}
+		return false
 	case bool:
 		y, ok := b.(bool)
 		return ok && x == y
@@ -80,8 +89,9 @@ func _eq(a, b luaValue, ls *luaState) bool {
 		switch y := b.(type) {
 		case int64:
 			return x == y
-		case float64:
-			return float64(x) == y
+		case float64: // equal only if y has an exact integer value
+			i, ok := number.FloatToInteger(y)
+			return ok && x == i
 		default:
 			return false
 		}
@@ -90,7 +100,8 @@ func _eq(a, b luaValue, ls *luaState) bool {
 		case float64:
 			return x == y
 		case int64:
-			return x == float64(y)
+			i, ok := number.FloatToInteger(x)
+			return ok && i == y
 		default:
 			return false
 		}
@@ -110,12 +121,12 @@ func _lt(a, b luaValue, ls *luaState) bool {
 		case int64:
 			return x < y
 		case float64:
-			return float64(x) < y
+			return ltIntFloat(x, y)
 		}
 	case float64:
 		switch y := b.(type) {
-		case int64:
-			return x < float64(y)
+		case int64: // without NaN, (a < b) is not (b <= a)
+			return !math.IsNaN(x) && !leIntFloat(y, x)
 		case float64:
 			return x < y
 		}
@@ -123,5 +134,43 @@ func _lt(a, b luaValue, ls *luaState) bool {
 	if result, ok := callMetamethod(a, b, "__lt", ls); ok {
 		return convertToBoolean(result)
 	}
-	panic("comparision error")
+	ls.orderError(a, b)
+	return false
+}
+
+// integers in [-2^NBM, 2^NBM] have an exact float representation
+const NBM = 53
+
+func intFitsFloat(i int64) bool {
+	return -(1<<NBM) <= i && i <= (1<<NBM)
+}
+
+// lua-5.3.4/src/lvm.c#LTintfloat
+// checks whether i < f; if i has no exact float representation, f is
+// either far away from it or an integral value, and they are compared as
+// integers. Comparisons with NaN are false
+func ltIntFloat(i int64, f float64) bool {
+	if !intFitsFloat(i) {
+		if f >= -math.MinInt64 { // -minint == maxint + 1
+			return true // f >= maxint + 1 > i
+		} else if f > math.MinInt64 { // minint < f <= maxint ?
+			return i < int64(f) // compare them as integers
+		}
+		return false // f <= minint <= i (or f is NaN) --> not(i < f)
+	}
+	return float64(i) < f // compare them as floats
+}
+
+// lua-5.3.4/src/lvm.c#LEintfloat
+// checks whether i <= f, see ltIntFloat
+func leIntFloat(i int64, f float64) bool {
+	if !intFitsFloat(i) {
+		if f >= -math.MinInt64 { // -minint == maxint + 1
+			return true // f >= maxint + 1 > i
+		} else if f >= math.MinInt64 { // minint <= f <= maxint ?
+			return i <= int64(f) // compare them as integers
+		}
+		return false // f < minint <= i (or f is NaN) --> not(i <= f)
+	}
+	return float64(i) <= f // compare them as floats
 }
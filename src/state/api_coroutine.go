@@ -0,0 +1,152 @@
+package state
+
+import (
+	"api"
+	"runtime"
+)
+
+// value sent to a suspended coroutine to make it unwind, see closeThread
+const CO_CLOSE = 0
+
+// [-0, +1, m]
+// http://www.lua.org/manual/5.3/manual.html#lua_newthread
+func (self *luaState) NewThread() api.LuaState {
+	t := &luaState{registry: self.registry, global: self.global}
+	t.hook = self.hook // the new thread inherits the hook
+	t.hookMask = self.hookMask
+	t.baseHookCount = self.baseHookCount
+	t.hookCount = self.baseHookCount
+	t.pushLuaStack(newLuaStack(api.LUA_MINSATCK, t))
+	self.stack.push(t)
+	return t
+}
+
+// [-?, +?, –]
+// http://www.lua.org/manual/5.3/manual.html#lua_resume
+func (self *luaState) Resume(from api.LuaState, nArgs int) int {
+	lsFrom := from.(*luaState)
+	if lsFrom.coChan == nil {
+		lsFrom.coChan = make(chan int)
+	}
+	if self.coChan == nil {
+		if self.stack.prev != nil { // already running?
+			return self.resumeError("cannot resume non-suspended coroutine", nArgs)
+		}
+		// start coroutine
+		self.coChan = make(chan int)
+		self.coCaller = lsFrom
+		self.global.coroutines[self] = true
+		go func() {
+			defer self.finish()
+			self.coStatus = self.PCall(nArgs, api.LUA_MULTRET, 0)
+		}()
+	} else {
+		// resume coroutine
+		switch self.coStatus {
+		case api.LUA_YIELD:
+		case api.LUA_OK:
+			return self.resumeError("cannot resume non-suspended coroutine", nArgs)
+		default:
+			return self.resumeError("cannot resume dead coroutine", nArgs)
+		}
+		self.coStatus = api.LUA_OK
+		self.coCaller = lsFrom
+		self.coChan <- 1
+	}
+	<-lsFrom.coChan // wait coroutine to finish or yield
+	return self.coStatus
+}
+
+// hands control back to the resuming thread once the goroutine of the
+// coroutine ends: it returned, failed or was closed
+func (self *luaState) finish() {
+	delete(self.global.coroutines, self)
+	caller := self.coCaller
+	self.coCaller = nil
+	caller.coChan <- 1
+}
+
+// lua-5.4.0/src/lstate.c#lua_resetthread
+// makes the goroutine of a suspended coroutine unwind and waits for it
+// to end; the coroutine is dead afterwards
+func (self *luaState) closeThread(from *luaState) {
+	if from.coChan == nil {
+		from.coChan = make(chan int)
+	}
+	self.coCaller = from
+	self.coChan <- CO_CLOSE
+	<-from.coChan
+	self.coStatus = api.LUA_ERRRUN
+	for self.stack.prev != nil {
+		self.popLuaStack()
+	}
+	for self.stack.top > 0 {
+		self.stack.pop()
+	}
+}
+
+// lua-5.3.4/src/ldo.c#resume_error()
+func (self *luaState) resumeError(msg string, nArgs int) int {
+	self.Pop(nArgs) // remove args from the stack
+	self.PushString(msg)
+	return api.LUA_ERRRUN
+}
+
+// [-?, +?, e]
+// http://www.lua.org/manual/5.3/manual.html#lua_yield
+func (self *luaState) Yield(nResults int) int {
+	if self.coCaller == nil {
+		self.runError("attempt to yield from outside a coroutine")
+	}
+	self.coStatus = api.LUA_YIELD
+	caller := self.coCaller
+	self.coCaller = nil // yieldable again once resumed
+	caller.coChan <- 1
+	if <-self.coChan == CO_CLOSE { // wait to be resumed
+		runtime.Goexit() // deferred functions run, recover() does not stop it
+	}
+	return self.GetTop()
+}
+
+// [-0, +0, –]
+// http://www.lua.org/manual/5.3/manual.html#lua_status
+func (self *luaState) Status() int {
+	return self.coStatus
+}
+
+// [-0, +0, –]
+// http://www.lua.org/manual/5.3/manual.html#lua_isyieldable
+// only a running coroutine can yield
+func (self *luaState) IsYieldable() bool {
+	return self.coCaller != nil
+}
+
+// [-0, +0, –]
+// http://www.lua.org/manual/5.3/manual.html#lua_tothread
+func (self *luaState) ToThread(idx int) api.LuaState {
+	val := self.stack.get(idx)
+	if t, ok := val.(*luaState); ok {
+		return t
+	}
+	return nil
+}
+
+// [-0, +1, –]
+// http://www.lua.org/manual/5.3/manual.html#lua_pushthread
+func (self *luaState) PushThread() bool {
+	self.stack.push(self)
+	return self.isMainThread()
+}
+
+// [-?, +?, –]
+// http://www.lua.org/manual/5.3/manual.html#lua_xmove
+func (self *luaState) XMove(to api.LuaState, n int) {
+	vals := self.stack.popN(n)
+	ls := to.(*luaState)
+	ls.stack.check(n)
+	ls.stack.pushN(vals, n)
+}
+
+func (self *luaState) isMainThread() bool {
+	return self.registry.get(api.LUA_RIDX_MAINTHREAD) == self
+}
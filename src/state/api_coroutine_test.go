@@ -0,0 +1,92 @@
+package state
+
+import (
+	"api"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestIsYieldable(t *testing.T) {
+	ls := New()
+	ls.OpenLibs()
+	err := ls.DoStringE(`
+		co = coroutine.create(function()
+			assert(coroutine.isyieldable())
+			coroutine.yield()
+		end)
+		assert(not coroutine.isyieldable())`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ls.GetGlobal("co")
+	co := ls.ToThread(-1)
+	for _, state := range []string{"suspended", "dead"} {
+		if err := ls.DoStringE(`assert(coroutine.resume(co))`); err != nil {
+			t.Fatal(err)
+		}
+		if co.IsYieldable() {
+			t.Errorf("%s coroutine is yieldable", state)
+		}
+	}
+}
+
+// waits a little for the goroutines that are ending
+func numGoroutine(want int) int {
+	n := runtime.NumGoroutine()
+	for i := 0; i < 100 && n > want; i++ {
+		time.Sleep(time.Millisecond)
+		n = runtime.NumGoroutine()
+	}
+	return n
+}
+
+const suspendCoroutines = `
+	local mt = {__gc = function() unwound = (unwound or 0) + 1 end}
+	for i = 1, 100 do
+		local co = coroutine.wrap(function()
+			local guard = setmetatable({}, mt)
+			coroutine.yield()
+		end)
+		co()
+		kept = co
+	end`
+
+func TestCollectSuspendedCoroutines(t *testing.T) {
+	before := runtime.NumGoroutine()
+	ls := New()
+	ls.OpenLibs()
+	if err := ls.DoStringE(suspendCoroutines + `
+		collectgarbage()
+		assert(unwound == 99, unwound)`); err != nil {
+		t.Fatal(err)
+	}
+	if n := numGoroutine(before + 1); n != before+1 {
+		t.Fatalf("%d goroutines, want %d", n, before+1)
+	}
+	// the coroutine still reachable can be resumed
+	if err := ls.DoStringE(`kept()`); err != nil {
+		t.Fatal(err)
+	}
+	if n := numGoroutine(before); n != before {
+		t.Fatalf("%d goroutines, want %d", n, before)
+	}
+}
+
+func TestCloseUnwindsCoroutines(t *testing.T) {
+	before := runtime.NumGoroutine()
+	ls := New()
+	ls.OpenLibs()
+	ls.GC(api.LUA_GCSTOP, 0)
+	if err := ls.DoStringE(suspendCoroutines); err != nil {
+		t.Fatal(err)
+	}
+	ls.Close()
+	if n := numGoroutine(before); n != before {
+		t.Fatalf("%d goroutines, want %d", n, before)
+	}
+	ls.GetGlobal("unwound")
+	if n := ls.ToInteger(-1); n != 100 {
+		t.Fatalf("%d finalizers ran, want 100", n)
+	}
+}
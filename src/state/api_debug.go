@@ -0,0 +1,167 @@
+package state
+
+import (
+	"api"
+	"strings"
+	"unsafe"
+)
+
+// [-0, +0, –]
+// http://www.lua.org/manual/5.3/manual.html#lua_getstack
+func (self *luaState) GetStack(level int, ar *api.Debug) bool {
+	if level < 0 {
+		return false // invalid (negative) level
+	}
+	if frame := self.getFrame(level); frame != nil {
+		if ar != nil {
+			ar.CallInfo = frame
+		}
+		return true
+	}
+	return false
+}
+
+// [-0, +0, –]
+// http://www.lua.org/manual/5.3/manual.html#lua_sethook
+func (self *luaState) SetHook(f api.Hook, mask, count int) {
+	if f == nil || mask == 0 { // turn off hooks?
+		f, mask = nil, 0
+	}
+	if self.stack.isLua() {
+		self.oldPC = self.stack.pc
+	}
+	self.hook = f
+	self.baseHookCount = count
+	self.hookCount = count
+	self.hookMask = mask
+}
+
+// [-0, +0, –]
+// http://www.lua.org/manual/5.3/manual.html#lua_gethook
+func (self *luaState) GetHook() api.Hook {
+	return self.hook
+}
+
+// [-0, +0, –]
+// http://www.lua.org/manual/5.3/manual.html#lua_gethookmask
+func (self *luaState) GetHookMask() int {
+	return self.hookMask
+}
+
+// [-0, +0, –]
+// http://www.lua.org/manual/5.3/manual.html#lua_gethookcount
+func (self *luaState) GetHookCount() int {
+	return self.baseHookCount
+}
+
+// [-(0|1), +(0|1|2), e]
+// http://www.lua.org/manual/5.3/manual.html#lua_getinfo
+func (self *luaState) GetInfo(what string, ar *api.Debug) bool {
+	var frame *luaStack
+	var fn luaValue
+	if strings.HasPrefix(what, ">") {
+		fn = self.stack.pop()
+		what = what[1:] // skip the '>'
+	} else {
+		frame = ar.CallInfo.(*luaStack)
+		fn = frame.closure
+	}
+	c, _ := fn.(*closure)
+	status := auxGetInfo(what, ar, c, frame)
+	if strings.ContainsRune(what, 'f') {
+		self.stack.check(1)
+		self.stack.push(fn)
+	}
+	if strings.ContainsRune(what, 'L') {
+		self.stack.check(1)
+		self.stack.push(collectValidLines(c))
+	}
+	return status
+}
+
+// [-0, +(0|1), –]
+// http://www.lua.org/manual/5.3/manual.html#lua_getlocal
+func (self *luaState) GetLocal(ar *api.Debug, n int) string {
+	if ar == nil { // information about non-active function?
+		if c, ok := self.stack.get(-1).(*closure); ok && c.proto != nil {
+			// consider live variables at function start (parameters)
+			return getLocalName(c.proto, n, 0)
+		}
+		return "" // not a Lua function
+	}
+	name, slot := findLocal(ar.CallInfo.(*luaStack), n)
+	if name != "" {
+		self.stack.check(1)
+		self.stack.push(*slot)
+	}
+	return name
+}
+
+// [-(0|1), +0, –]
+// http://www.lua.org/manual/5.3/manual.html#lua_setlocal
+// the value is popped only when the local exists
+func (self *luaState) SetLocal(ar *api.Debug, n int) string {
+	name, slot := findLocal(ar.CallInfo.(*luaStack), n)
+	if name != "" {
+		*slot = self.stack.pop()
+	}
+	return name
+}
+
+// [-0, +(0|1), –]
+// http://www.lua.org/manual/5.3/manual.html#lua_getupvalue
+// the name of upvalues of Go functions is ""; ok is false if there is
+// no such upvalue, nothing is pushed then
+func (self *luaState) GetUpvalue(funcIdx, n int) (name string, ok bool) {
+	name, uv, ok := auxUpvalue(self.stack.get(funcIdx), n)
+	if ok {
+		var val luaValue
+		if *uv != nil {
+			val = *(*uv).val
+		}
+		self.stack.check(1)
+		self.stack.push(val)
+	}
+	return name, ok
+}
+
+// [-(0|1), +0, –]
+// http://www.lua.org/manual/5.3/manual.html#lua_setupvalue
+// the value is popped only when the upvalue exists
+func (self *luaState) SetUpvalue(funcIdx, n int) (name string, ok bool) {
+	name, uv, ok := auxUpvalue(self.stack.get(funcIdx), n)
+	if ok {
+		val := self.stack.pop()
+		if *uv == nil {
+			*uv = &upvalue{&val}
+		} else {
+			*(*uv).val = val
+		}
+	}
+	return name, ok
+}
+
+// [-0, +0, –]
+// http://www.lua.org/manual/5.3/manual.html#lua_upvalueid
+func (self *luaState) UpvalueID(funcIdx, n int) unsafe.Pointer {
+	_, uv, ok := auxUpvalue(self.stack.get(funcIdx), n)
+	if !ok {
+		return nil
+	}
+	if *uv == nil { // give it an identity
+		*uv = &upvalue{new(luaValue)}
+	}
+	return unsafe.Pointer(*uv)
+}
+
+// [-0, +0, –]
+// http://www.lua.org/manual/5.3/manual.html#lua_upvaluejoin
+func (self *luaState) UpvalueJoin(funcIdx1, n1, funcIdx2, n2 int) {
+	_, uv1, ok1 := auxUpvalue(self.stack.get(funcIdx1), n1)
+	_, uv2, ok2 := auxUpvalue(self.stack.get(funcIdx2), n2)
+	if !ok1 || !ok2 {
+		self.runError("invalid upvalue index")
+	}
+	self.UpvalueID(funcIdx2, n2) // make sure there is an upvalue to share
+	*uv1 = *uv2
+}
@@ -0,0 +1,41 @@
+package state
+
+import (
+	"api"
+	"testing"
+)
+
+func TestDebugLibArguments(t *testing.T) {
+	ls := New()
+	ls.OpenLibs()
+	err := ls.DoStringE(`
+		for _, what in ipairs{print, 1} do
+			local ok, msg = pcall(function() debug.getinfo(what, ">S") end)
+			assert(msg:find("bad argument #2 to 'getinfo' (invalid option)", 1, true), msg)
+		end
+		assert(select("#", debug.gethook()) == 0)
+		debug.sethook(function() end, "c")
+		assert(select("#", debug.gethook()) == 3)
+		debug.sethook()`)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestUpvalueJoinInvalidIndex(t *testing.T) {
+	ls := New()
+	ls.OpenLibs()
+	if err := ls.LoadStringE(`local x; return function() return x end`); err != nil {
+		t.Fatal(err)
+	}
+	ls.Call(0, 1)
+	ls.PushGoFunction(func(ls api.LuaState) int {
+		ls.UpvalueJoin(1, 1, 1, 2)
+		return 0
+	})
+	ls.Insert(-2)
+	err := ls.CallE(1, 0)
+	if e, ok := err.(*api.LuaError); !ok || e.Message != "invalid upvalue index" {
+		t.Fatalf("got %v, want a Lua error", err)
+	}
+}
@@ -0,0 +1,105 @@
+package state
+
+import (
+	"api"
+	"runtime"
+)
+
+// [-0, +0, m]
+// http://www.lua.org/manual/5.3/manual.html#lua_gc
+// Memory is managed by Go's collector. A cycle walks the objects reachable
+// from the state to find those whose __gc metamethod must run; "stop" and
+// "restart" only control automatic cycles and finalizers, and "count" is
+// MemoryInUse, the size of the objects found by the walk plus the bytes
+// allocated since.
+func (self *luaState) GC(what, data int) int {
+	g := self.global
+	switch what {
+	case api.LUA_GCSTOP:
+		g.gcStopped = true
+	case api.LUA_GCRESTART:
+		g.gcStopped = false
+	case api.LUA_GCCOLLECT:
+		self.fullGC()
+		if !g.gcStopped && !g.inFinalizer {
+			self.runFinalizers()
+		}
+	case api.LUA_GCCOUNT: // GC values are expressed in Kbytes
+		return int(g.totalBytes >> 10)
+	case api.LUA_GCCOUNTB:
+		return int(g.totalBytes & 0x3ff)
+	case api.LUA_GCSTEP:
+		self.fullGC()
+		if !g.gcStopped && !g.inFinalizer {
+			self.runFinalizers()
+		}
+		return 1 // each step is a full cycle
+	case api.LUA_GCSETPAUSE:
+		data, g.gcPause = g.gcPause, data
+		return data
+	case api.LUA_GCSETSTEPMUL:
+		data, g.gcStepMul = g.gcStepMul, data
+		return data
+	case api.LUA_GCISRUNNING:
+		if g.gcStopped {
+			return 0
+		}
+		return 1
+	default:
+		return -1 // invalid option
+	}
+	return 0
+}
+
+// [-0, +0, –]
+// bytes held by the tables, strings, closures and userdata of the state:
+// the live objects found by the last estimate plus everything allocated
+// since. The estimate is made again by each cycle of the collector, and
+// when the total goes over the memory limit
+func (self *luaState) MemoryInUse() int64 {
+	return self.global.totalBytes
+}
+
+// [-0, +0, –]
+// caps MemoryInUse: allocations that would go over limit, even after a new
+// estimate of the live objects, raise a LUA_ERRMEM error ("not enough
+// memory"). Message handlers do not run for such errors. A limit <= 0
+// removes the cap.
+func (self *luaState) SetMemoryLimit(limit int64) {
+	if limit < 0 {
+		limit = 0
+	}
+	self.global.memLimit = limit
+}
+
+// [-0, +0, –]
+// limit set by SetMemoryLimit, 0 if there is none
+func (self *luaState) MemoryLimit() int64 {
+	return self.global.memLimit
+}
+
+// [-0, +0, –]
+// http://www.lua.org/manual/5.3/manual.html#lua_close
+// unwinds the suspended coroutines, so that their goroutines end, and runs
+// the __gc metamethods of all objects. The state must not be used
+// afterwards
+func (self *luaState) Close() {
+	g := self.global
+	for co := range g.coroutines {
+		if co.coStatus == api.LUA_YIELD {
+			co.closeThread(self)
+		}
+	}
+	for i := len(g.finobj) - 1; i >= 0; i-- { // newest are finalized first
+		g.tobefnz = append(g.tobefnz, g.finobj[i])
+	}
+	g.finobj = nil
+	self.runFinalizers()
+}
+
+// runs a full cycle; Go's collector runs first, so that weak tables lose
+// the entries it collected
+func (self *luaState) fullGC() {
+	runtime.GC()
+	self.collect()
+}
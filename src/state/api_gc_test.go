@@ -0,0 +1,154 @@
+package state
+
+import (
+	"api"
+	"runtime"
+	"testing"
+)
+
+func doBoolean(t *testing.T, chunk string) bool {
+	t.Helper()
+	ls := New()
+	ls.OpenLibs()
+	if err := ls.LoadStringE(chunk); err != nil {
+		t.Fatal(err)
+	}
+	if err := ls.CallE(0, 1); err != nil {
+		t.Fatal(err)
+	}
+	return ls.ToBoolean(-1)
+}
+
+func TestFinalizeCycles(t *testing.T) {
+	chunks := map[string]string{
+		"self reference": `
+			local done = false
+			local t = setmetatable({}, {__gc = function() done = true end})
+			t.self = t
+			t = nil
+			collectgarbage()
+			return done`,
+		"metamethods reaching the object": `
+			local done = false
+			local function make()
+				local o = {}
+				setmetatable(o, {
+					__index = function() return o end,
+					__gc = function(x) done = x == o end,
+				})
+			end
+			make()
+			collectgarbage()
+			return done`,
+		"cycle through another table": `
+			local n = 0
+			local mt = {__gc = function() n = n + 1 end}
+			local a = setmetatable({}, mt)
+			local b = setmetatable({a = a}, mt)
+			a.b = b
+			a, b = nil, nil
+			collectgarbage()
+			return n == 2`,
+		"automatic cycles": `
+			local n = 0
+			local mt = {__gc = function() n = n + 1 end}
+			for i = 1, 10000 do
+				local t = setmetatable({}, mt)
+				t.self = t
+			end
+			return n > 0`,
+	}
+	for name, chunk := range chunks {
+		if !doBoolean(t, chunk) {
+			t.Errorf("%s: __gc did not run", name)
+		}
+	}
+}
+
+func TestKeepReachableObjects(t *testing.T) {
+	chunk := `
+		local done = false
+		local mt = {__gc = function() done = true end}
+		local t = setmetatable({}, mt)
+		t.self = t
+		local weak = setmetatable({}, {__mode = "k"})
+		weak[t] = true
+		collectgarbage()
+		local ok = not done and weak[t]
+		t = nil
+		collectgarbage()
+		return ok and done`
+	if !doBoolean(t, chunk) {
+		t.Fatal("finalized a reachable object, or not an unreachable one")
+	}
+}
+
+func TestMemoryLimitCoversLibraryStrings(t *testing.T) {
+	chunks := []string{
+		`return string.rep("x", 1 << 30)`,
+		`return string.rep("x", 1 << 20, "yy")`,
+		`return string.format("%s%s", string.rep("x", 600000), string.rep("x", 600000))`,
+		`return (string.gsub(string.rep("x", 2000), "x", string.rep("y", 1000)))`,
+	}
+	for _, chunk := range chunks {
+		ls := New()
+		ls.OpenLibs()
+		ls.SetMemoryLimit(1 << 20)
+		var before, after runtime.MemStats
+		runtime.ReadMemStats(&before)
+		err := ls.DoStringE(chunk)
+		runtime.ReadMemStats(&after)
+		if e, ok := err.(*api.LuaError); !ok || e.Status != api.LUA_ERRMEM {
+			t.Errorf("%s: got %v, want a memory error", chunk, err)
+		}
+		if allocated := after.TotalAlloc - before.TotalAlloc; allocated > 16<<20 {
+			t.Errorf("%s: allocated %d bytes", chunk, allocated)
+		}
+	}
+}
+
+func TestMemoryLimitAfterGarbage(t *testing.T) {
+	ls := New()
+	ls.OpenLibs()
+	ls.SetMemoryLimit(1 << 20)
+	// garbage is found out by the walk made once the limit is reached
+	err := ls.DoStringE(`
+		for i = 1, 1000 do
+			local s = string.rep("x", 10000)
+		end`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := ls.MemoryInUse(); n > 1<<20 {
+		t.Fatalf("%d bytes in use, over the limit", n)
+	}
+}
+
+func TestMemoryLimitErrors(t *testing.T) {
+	ls := New()
+	ls.OpenLibs()
+	ls.SetMemoryLimit(4 << 20)
+	err := ls.DoStringE(`local t = {} for i = 1, 1e7 do t[i] = i end`)
+	if e, ok := err.(*api.LuaError); !ok || e.Status != api.LUA_ERRMEM || e.Message != "not enough memory" {
+		t.Fatalf("table growth: got %v, want a memory error", err)
+	}
+	// pcall catches the error, and the script goes on once the garbage
+	// left by the failed calls is found out
+	err = ls.DoStringE(`
+		local function grow()
+			local t = {}
+			for i = 1, 1e7 do t[i] = i end
+		end
+		for _, f in ipairs{grow, function() return string.rep("x", 1 << 24) end} do
+			for i = 1, 3 do
+				local ok, msg = pcall(f)
+				assert(not ok and msg == "not enough memory", msg)
+			end
+		end
+		local t = {}
+		for i = 1, 1000 do t[i] = string.rep("x", 100) .. i end
+		assert(#t == 1000)`)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -3,6 +3,7 @@ package state
 import "api"
 
 func (self *luaState) CreateTable(nArr, nRec int) {
+	self.allocate(sizeTable + nArr*sizeValue + nRec*sizeNode)
 	self.stack.push(newLuaTable(nArr, nRec))
 }
 
@@ -16,30 +17,35 @@ func (self *luaState) GetTable(idx int) api.LuaType {
 	return self.getTable(t, k, false)
 }
 
+// lua-5.3.4/src/lvm.c#luaV_finishget
+// pushes t[k], following the __index chain (up to MAXTAGLOOP links) unless
+// raw is set
 func (self *luaState) getTable(t, k luaValue, raw bool) api.LuaType {
-	if tbl, ok := t.(*luaTable); ok {
-		v := tbl.get(k)
-		if raw || v != nil || !tbl.hasMetafield("__index") {
-			self.stack.push(v)
-			return typeOf(v)
-		}
-	}
-	if !raw {
-		if mf := getMetaField(t, "__index", self); mf != nil {
-			switch x := mf.(type) {
-			case *luaTable:
-				return self.getTable(x, k, false)
-			case *closure:
-				self.stack.push(mf)
-				self.stack.push(t)
-				self.stack.push(k)
-				self.Call(2, 1)
-				v := self.stack.get(-1)
+	for loop := 0; loop < MAXTAGLOOP; loop++ {
+		var tm luaValue
+		if tbl, ok := t.(*luaTable); ok {
+			v := tbl.get(k)
+			if raw || v != nil || !tbl.hasMetafield("__index") {
+				self.stack.push(v)
 				return typeOf(v)
 			}
+			tm = tbl.metatable.get("__index")
+		} else if raw {
+			self.runTypeError(t, 0, "index")
+		} else if tm = getMetaField(t, "__index", self); tm == nil {
+			self.runTypeError(t, 0, "index")
 		}
+		if c, ok := tm.(*closure); ok {
+			self.stack.push(c)
+			self.stack.push(t)
+			self.stack.push(k)
+			self.Call(2, 1)
+			return typeOf(self.stack.get(-1))
+		}
+		t = tm // else try to access 'tm[key]'
 	}
-	panic("not a table")
+	self.runError("'__index' chain too long; possible loop")
+	return api.LUA_TNONE
 }
 
 func (self *luaState) GetField(idx int, k string) api.LuaType {
@@ -76,3 +82,13 @@ func (self *luaState) RawGetI(idx int, i int64) api.LuaType {
 	t := self.stack.get(idx)
 	return self.getTable(t, i, true)
 }
+
+func (self *luaState) GetUservalue(idx int) api.LuaType {
+	val := self.stack.get(idx)
+	u, ok := val.(*userdata)
+	if !ok {
+		self.runError("full userdata expected")
+	}
+	self.stack.push(u.uservalue)
+	return typeOf(u.uservalue)
+}
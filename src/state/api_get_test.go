@@ -0,0 +1,29 @@
+package state
+
+import (
+	"api"
+	"testing"
+)
+
+func TestUservalueOfOtherTypes(t *testing.T) {
+	funcs := map[string]api.GoFunction{
+		"GetUservalue": func(ls api.LuaState) int {
+			ls.GetUservalue(1)
+			return 1
+		},
+		"SetUservalue": func(ls api.LuaState) int {
+			ls.PushNil()
+			ls.SetUservalue(1)
+			return 0
+		},
+	}
+	for name, f := range funcs {
+		ls := New()
+		ls.PushGoFunction(f)
+		ls.PushInteger(1)
+		err := ls.CallE(1, 0)
+		if e, ok := err.(*api.LuaError); !ok || e.Message != "full userdata expected" {
+			t.Errorf("%s: got %v, want a Lua error", name, err)
+		}
+	}
+}
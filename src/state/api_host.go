@@ -0,0 +1,167 @@
+package state
+
+import (
+	"api"
+	"context"
+	"math/rand"
+	"time"
+)
+
+// step of the virtual clock, in seconds
+const VIRTUAL_CLOCK_TICK = 0.001
+
+// [-0, +0, –]
+// switches the state, and all its threads, to deterministic execution:
+// math.random is reseeded with seed, os.clock and os.time become virtual,
+// tostring shows object ids instead of addresses, os.getenv sees an empty
+// environment and os.tmpname, os.remove, os.rename and os.exit raise
+// errors. Given the same seed and the same host functions, two runs of a
+// chunk then produce byte-identical output, as long as it does not rely on
+// weak tables losing entries other than by collectgarbage(). Table
+// traversal always follows insertion order.
+func (self *luaState) SetDeterministic(seed int64) {
+	g := self.global
+	g.deterministic = true
+	g.rand = rand.New(rand.NewSource(seed))
+	g.clock = 0
+}
+
+// [-0, +0, –]
+func (self *luaState) IsDeterministic() bool {
+	return self.global.deterministic
+}
+
+// the global state is the stdlib.Host of its threads, stored in the
+// registry; these methods are not part of the API
+
+// random number generator of the state, used by math.random
+func (self *globalState) Rand() *rand.Rand {
+	return self.rand
+}
+
+// seconds elapsed since the state was created, used by os.clock; in
+// deterministic mode a virtual clock advancing VIRTUAL_CLOCK_TICK per call
+func (self *globalState) Clock() float64 {
+	if self.deterministic {
+		self.clock += VIRTUAL_CLOCK_TICK
+		return self.clock
+	}
+	return time.Since(self.startTime).Seconds()
+}
+
+// current time, used by os.time and os.date; in deterministic mode the
+// epoch advanced by the virtual clock
+func (self *globalState) Time() time.Time {
+	if self.deterministic {
+		return time.Unix(int64(self.clock), 0).UTC()
+	}
+	return time.Now()
+}
+
+// [-0, +0, –]
+// attaches ctx to the state and all its threads: once ctx is done, running
+// Lua code raises "interrupted: " .. ctx.Err() within CONTEXT_CHECK_INTERVAL
+// instructions. The error can be caught by pcall, but from then on every
+// instruction raises it again, so the script cannot go on. A nil ctx
+// detaches the context.
+func (self *luaState) SetContext(ctx context.Context) {
+	g := self.global
+	g.ctx = ctx
+	g.ctxDone = nil
+	if ctx != nil {
+		g.ctxDone = ctx.Done()
+	}
+	g.ctxCountdown = 0 // check before the next instruction
+}
+
+// [-0, +0, –]
+// context attached to the state, context.Background() if there is none
+func (self *luaState) Context() context.Context {
+	if ctx := self.global.ctx; ctx != nil {
+		return ctx
+	}
+	return context.Background()
+}
+
+// raises the interruption error if the context is done, called by the
+// VM every CONTEXT_CHECK_INTERVAL instructions, and before each one once
+// the context is done
+func (self *luaState) checkContext() {
+	g := self.global
+	select {
+	case <-g.ctxDone:
+		g.ctxCountdown = 0 // check again before the next instruction
+		self.interrupt("interrupted: "+g.ctx.Err().Error(), g.ctx.Err())
+	default:
+		g.ctxCountdown = CONTEXT_CHECK_INTERVAL
+	}
+}
+
+// [-0, +0, –]
+// caps the work of the state and all its threads: each instruction run
+// costs the weight of its opcode (see SetOpWeight) and once the budget is
+// spent, running Lua code raises "budget exhausted". Like an interruption,
+// the error can be caught by pcall, but the next instruction that cannot be
+// paid raises it again. A negative budget turns metering off.
+func (self *luaState) SetBudget(budget int64) {
+	g := self.global
+	g.metering = budget >= 0
+	g.budget = budget
+}
+
+// [-0, +0, –]
+// what is left of the budget, -1 if metering is off
+func (self *luaState) Budget() int64 {
+	if g := self.global; g.metering {
+		return g.budget
+	}
+	return -1
+}
+
+// [-0, +0, –]
+// sets the cost of the instructions with opcode op (vm.OP_CALL, vm.OP_CONCAT...),
+// which is 1 by default
+func (self *luaState) SetOpWeight(op int, weight int64) {
+	self.global.opWeights[op] = weight
+}
+
+// pays for an instruction with opcode op, called by the VM while metering
+func (self *luaState) charge(op int) {
+	g := self.global
+	if w := g.opWeights[op]; w <= g.budget {
+		g.budget -= w
+	} else {
+		self.interrupt("budget exhausted", api.ErrBudgetExhausted)
+	}
+}
+
+// raises the error of an interrupted script; the state remembers cause,
+// which CallE reports as Err if the call fails, whatever becomes of the
+// error object on the way out
+func (self *luaState) interrupt(msg string, cause error) {
+	self.global.interruptCause = cause
+	self.runError("%s", msg)
+}
+
+// reproducible stand-in for the address of the object at idx: objects are
+// numbered in the order they are first asked for
+func (self *luaState) objectID(idx int) uint64 {
+	var id *uint64
+	switch x := self.stack.get(idx).(type) {
+	case *luaTable:
+		id = &x.id
+	case *closure:
+		id = &x.id
+	case *luaState:
+		id = &x.id
+	case *userdata:
+		id = &x.id
+	default:
+		return uint64(uintptr(self.ToPointer(idx)))
+	}
+	if *id == 0 {
+		self.global.lastID++
+		*id = self.global.lastID
+	}
+	return *id
+}
@@ -0,0 +1,145 @@
+package state
+
+import (
+	"api"
+	"context"
+	"errors"
+	"testing"
+	"time"
+	"vm"
+)
+
+func TestContextInterruptCannotBeCaught(t *testing.T) {
+	ls := New()
+	ls.OpenLibs()
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	done := make(chan error, 1)
+	go func() {
+		done <- doStringContext(ls, ctx, `while true do pcall(function() while true do end end) end`)
+	}()
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatalf("got %v, want an interruption", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("script caught the interruption and kept running")
+	}
+}
+
+func TestContextInterruptAfterPCall(t *testing.T) {
+	ls := New()
+	ls.OpenLibs()
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	// the first pcall outlives the deadline, the script must not finish
+	err := doStringContext(ls, ctx, `
+		local ok = pcall(function() while true do end end)
+		for i = 1, 10 do local x = i end
+		return ok`)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("got %v, want an interruption", err)
+	}
+}
+
+func doStringContext(ls *luaState, ctx context.Context, chunk string) error {
+	if err := ls.LoadStringE(chunk); err != nil {
+		return err
+	}
+	return ls.CallContext(ctx, 0, api.LUA_MULTRET)
+}
+
+func TestInterruptionCauseSurvivesReraise(t *testing.T) {
+	chunks := []string{
+		// re-raised by coroutine.wrap, which adds a position
+		`coroutine.wrap(function() while true do end end)()`,
+		// caught and raised again with another level
+		`local ok, e = pcall(function() while true do end end) error(e, 2)`,
+		// message handler replacing the error object
+		`xpcall(function() while true do end end, function(m) return {m} end)`,
+	}
+	for _, chunk := range chunks {
+		ls := New()
+		ls.OpenLibs()
+		ls.SetBudget(10000)
+		err := ls.DoStringE(chunk)
+		if !errors.Is(err, api.ErrBudgetExhausted) {
+			t.Errorf("%s: got %v, want ErrBudgetExhausted", chunk, err)
+		}
+	}
+}
+
+func TestOrdinaryErrorIsNotAnInterruption(t *testing.T) {
+	ls := New()
+	ls.OpenLibs()
+	ls.SetBudget(10000)
+	err := ls.DoStringE(`error("budget exhausted")`)
+	if err == nil || errors.Is(err, api.ErrBudgetExhausted) {
+		t.Fatalf("got %v, want a plain error", err)
+	}
+	ls.SetBudget(5)
+	if err := ls.DoStringE(`while true do end`); !errors.Is(err, api.ErrBudgetExhausted) {
+		t.Fatalf("got %v, want ErrBudgetExhausted", err)
+	}
+	ls.SetBudget(10000)
+	if err := ls.DoStringE(`error("x")`); errors.Is(err, api.ErrBudgetExhausted) {
+		t.Fatal("cause of a previous call reported again")
+	}
+}
+
+func TestDeterministicOSLib(t *testing.T) {
+	ls := New()
+	ls.OpenLibs()
+	ls.SetDeterministic(42)
+	err := ls.DoStringE(`
+		assert(os.getenv("PATH") == nil)
+		for _, f in ipairs{"tmpname", "remove", "rename", "exit"} do
+			local ok, msg = pcall(os[f], "x", "y")
+			assert(not ok and msg:find("not available in deterministic mode"), f)
+		end
+		assert(os.clock() == 0.001 and os.time() == 0)`)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestHostFacilitiesRemoved(t *testing.T) {
+	ls := New()
+	ls.OpenLibs()
+	// the libraries raise an error instead of crashing the host
+	err := ls.DoStringE(`
+		local reg = debug.getregistry()
+		reg._HOST = nil
+		return math.random()`)
+	if err == nil {
+		t.Fatal("math.random ran without host facilities")
+	}
+}
+
+// iterations of a loop run before a budget of 10000 is spent
+func iterationsWithin(t *testing.T, setup func(ls *luaState)) int64 {
+	t.Helper()
+	ls := New()
+	ls.OpenLibs()
+	setup(ls)
+	ls.SetBudget(10000)
+	err := ls.DoStringE(`n = 0 while true do n = n + 1 end`)
+	if !errors.Is(err, api.ErrBudgetExhausted) {
+		t.Fatalf("got %v, want ErrBudgetExhausted", err)
+	}
+	ls.SetBudget(-1)
+	ls.GetGlobal("n")
+	return ls.ToInteger(-1)
+}
+
+func TestOpWeights(t *testing.T) {
+	light := iterationsWithin(t, func(ls *luaState) {})
+	heavy := iterationsWithin(t, func(ls *luaState) {
+		ls.SetOpWeight(vm.OP_ADD, 10)
+	})
+	// 6 instructions per iteration, 15 with the heavier addition
+	if light < 1000 || heavy*2 >= light {
+		t.Fatalf("%d iterations with the default weights, %d with a heavier OP_ADD", light, heavy)
+	}
+}
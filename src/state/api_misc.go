@@ -11,24 +11,40 @@ func (self *luaState) Len(idx int) {
 	} else if t, ok := val.(*luaTable); ok {
 		self.stack.push(int64(t.len()))
 	} else {
-		panic("length error")
+		self.runTypeError(val, 0, "get length of")
 	}
 }
 
 func (self *luaState) Error() int {
 	err := self.stack.pop()
-	panic(err)
+	panic(&luaError{status: api.LUA_ERRRUN, value: err})
 }
 
 func (self *luaState) PCall(nArgs, nRes, msgh int) (status int) {
 	caller := self.stack
+	oldTop := caller.top - (nArgs + 1) // function and arguments are removed on error
+	var handler luaValue
+	if msgh != 0 {
+		handler = self.stack.get(msgh)
+	}
 	status = api.LUA_ERRRUN
 	defer func() {
-		if err := recover(); err != nil {
+		if r := recover(); r != nil {
+			e := self.toLuaError(r)
+			status = e.status
+			errVal := e.value
+			// the failing frames are still linked, so the
+			// message handler can inspect them before they are dropped
+			if handler != nil && status == api.LUA_ERRRUN {
+				status, errVal = self.callMsgHandler(handler, errVal)
+			}
 			for self.stack != caller {
 				self.popLuaStack()
 			}
-			self.stack.push(err)
+			for self.stack.top > oldTop {
+				self.stack.pop()
+			}
+			self.stack.push(errVal)
 		}
 	}()
 	self.Call(nArgs, nRes)
@@ -36,6 +52,23 @@ func (self *luaState) PCall(nArgs, nRes, msgh int) (status int) {
 	return
 }
 
+// lua-5.3.4/src/ldebug.c#luaG_errormsg
+func (self *luaState) callMsgHandler(handler, errVal luaValue) (status int, val luaValue) {
+	inErrorHandler := self.inErrorHandler
+	self.inErrorHandler = true
+	defer func() {
+		self.inErrorHandler = inErrorHandler
+		if r := recover(); r != nil { // error while running the handler
+			status, val = api.LUA_ERRERR, "error in error handling"
+		}
+	}()
+	self.stack.check(2)
+	self.stack.push(handler)
+	self.stack.push(errVal)
+	self.Call(1, 1)
+	return api.LUA_ERRRUN, self.stack.pop()
+}
+
 func (self *luaState) RawLen(idx int) uint {
 	val := self.stack.get(idx)
 	switch x := val.(type) {
@@ -52,9 +85,13 @@ func (self *luaState) Next(idx int) bool {
 	val := self.stack.get(idx)
 	if t, ok := val.(*luaTable); ok {
 		key := self.stack.pop()
-		if nextKey := t.nextKey(key); nextKey != nil {
+		nextKey, nextVal, ok := t.next(key)
+		if !ok {
+			self.runError("invalid key to 'next'")
+		}
+		if nextKey != nil {
 			self.stack.push(nextKey)
-			self.stack.push(t.get(nextKey))
+			self.stack.push(nextVal)
 			return true
 		}
 		return false
@@ -70,6 +107,7 @@ func (self *luaState) Concat(n int) {
 			if self.IsString(-1) && self.IsString(-2) {
 				s2 := self.ToString(-1)
 				s1 := self.ToString(-2)
+				self.allocate(len(s1) + len(s2))
 				self.stack.pop()
 				self.stack.pop()
 				self.stack.push(s1 + s2)
@@ -81,7 +119,7 @@ func (self *luaState) Concat(n int) {
 				self.stack.push(res)
 				continue
 			}
-			panic("concat error")
+			self.concatError(a, b, n-1-i)
 		}
 	}
 }
@@ -3,6 +3,7 @@ package state
 import (
 	"api"
 	"fmt"
+	"unsafe"
 )
 
 func (self *luaState) PushNil() {
@@ -28,10 +29,12 @@ func (self *luaState) PushFString(fmtS string, a ...interface{}) string {
 }
 
 func (self *luaState) PushString(s string) {
+	self.allocate(len(s))
 	self.stack.push(s)
 }
 
 func (self *luaState) PushGoFunction(f api.GoFunction) {
+	self.allocate(sizeClosure)
 	self.stack.push(newGoClosure(f, 0))
 }
 
@@ -40,10 +43,24 @@ func (self *luaState) PushGlobalTable() {
 }
 
 func (self *luaState) PushGoClosure(f api.GoFunction, n int) {
+	self.allocate(sizeClosure + n*sizeUpvalue)
 	closure := newGoClosure(f, n)
 	for i := n; i > 0; i-- {
 		val := self.stack.pop()
-		closure.upvals[n-1] = &upvalue{&val}
+		closure.upvals[i-1] = &upvalue{&val}
 	}
 	self.stack.push(closure)
 }
+
+// [-0, +1, m]
+// http://www.lua.org/manual/5.3/manual.html#lua_newuserdata
+func (self *luaState) NewUserData(data interface{}) {
+	self.allocate(sizeUdata)
+	self.stack.push(newUserdata(data))
+}
+
+// [-0, +1, –]
+// http://www.lua.org/manual/5.3/manual.html#lua_pushlightuserdata
+func (self *luaState) PushLightUserData(p unsafe.Pointer) {
+	self.stack.push(lightUserdata(p))
+}
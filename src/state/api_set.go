@@ -1,6 +1,9 @@
 package state
 
-import "api"
+import (
+	"api"
+	"math"
+)
 
 func (self *luaState) SetTable(idx int) {
 	v := self.stack.pop()
@@ -8,31 +11,43 @@ func (self *luaState) SetTable(idx int) {
 	self.setTable(self.stack.get(idx), k, v, false)
 }
 
+// lua-5.3.4/src/lvm.c#luaV_finishset
+// does t[k] = v, following the __newindex chain (up to MAXTAGLOOP links)
+// unless raw is set
 func (self *luaState) setTable(t, k, v luaValue, raw bool) {
-	if tbl, ok := t.(*luaTable); ok {
-		if raw || tbl.get(k) != nil || !tbl.hasMetafield("__newindex") {
-			tbl.set(k, v)
-			return
-		}
-	}
-
-	if !raw {
-		if mf := getMetaField(t, "__newindex", self); mf != nil {
-			switch x := mf.(type) {
-			case *luaTable:
-				self.setTable(x, k, v, false)
-				return
-			case *closure:
-				self.stack.push(mf)
-				self.stack.push(t)
-				self.stack.push(k)
-				self.stack.push(v)
-				self.Call(3, 0)
+	for loop := 0; loop < MAXTAGLOOP; loop++ {
+		var tm luaValue
+		if tbl, ok := t.(*luaTable); ok {
+			if raw || tbl.get(k) != nil || !tbl.hasMetafield("__newindex") {
+				if k == nil {
+					self.runError("table index is nil")
+				} else if f, ok := k.(float64); ok && math.IsNaN(f) {
+					self.runError("table index is NaN")
+				}
+				size := tbl.footprint()
+				tbl.set(k, v)
+				if grown := tbl.footprint() - size; grown > 0 {
+					self.grow(grown)
+				}
 				return
 			}
+			tm = tbl.metatable.get("__newindex")
+		} else if raw {
+			self.runTypeError(t, 0, "index")
+		} else if tm = getMetaField(t, "__newindex", self); tm == nil {
+			self.runTypeError(t, 0, "index")
 		}
+		if c, ok := tm.(*closure); ok {
+			self.stack.push(c)
+			self.stack.push(t)
+			self.stack.push(k)
+			self.stack.push(v)
+			self.Call(3, 0)
+			return
+		}
+		t = tm // else repeat assignment over 'tm'
 	}
-	panic("not a table")
+	self.runError("'__newindex' chain too long; possible loop")
 }
 
 func (self *luaState) SetField(idx int, k string) {
@@ -70,7 +85,7 @@ func (self *luaState) RawSet(idx int) {
 	t := self.stack.get(idx)
 	v := self.stack.pop()
 	k := self.stack.pop()
-	self.setTable(t, v, k, true)
+	self.setTable(t, k, v, true)
 }
 
 func (self *luaState) RawSetI(idx int, i int64) {
@@ -78,3 +93,12 @@ func (self *luaState) RawSetI(idx int, i int64) {
 	v := self.stack.pop()
 	self.setTable(t, i, v, true)
 }
+
+func (self *luaState) SetUservalue(idx int) {
+	val := self.stack.get(idx)
+	u, ok := val.(*userdata)
+	if !ok {
+		self.runError("full userdata expected")
+	}
+	u.uservalue = self.stack.pop()
+}
@@ -11,10 +11,34 @@ func (self *luaState) AbsIndex(idx int) int {
 }
 
 func (self *luaState) CheckStack(n int) bool {
+	if free := len(self.stack.slots) - self.stack.top; n > free &&
+		self.nSlots+n-free > self.global.maxSlots {
+		return false // would exceed the slot limit
+	}
 	self.stack.check(n)
 	return true
 }
 
+// [-0, +0, –]
+// sets the limits on nested calls and on the stack slots used by each
+// thread of the state, non-positive values restore the defaults; exceeding
+// them raises a "stack overflow" error
+func (self *luaState) SetStackLimits(maxCalls, maxSlots int) {
+	if maxCalls <= 0 {
+		maxCalls = LUAI_MAXCALLS
+	}
+	if maxSlots <= 0 {
+		maxSlots = LUAI_MAXSLOTS
+	}
+	self.global.maxCalls = maxCalls
+	self.global.maxSlots = maxSlots
+}
+
+// [-0, +0, –]
+func (self *luaState) StackLimits() (maxCalls, maxSlots int) {
+	return self.global.maxCalls, self.global.maxSlots
+}
+
 func (self *luaState) Pop(n int) {
 	for i := 0; i < n; i++ {
 		self.stack.pop()
@@ -41,6 +41,7 @@ func (self *luaState) LoadVararg(n int) {
 
 func (self *luaState) LoadProto(idx int) {
 	proto := self.stack.closure.proto.Protos[idx]
+	self.allocate(sizeClosure + len(proto.Upvalues)*sizeUpvalue)
 	closure := newLuaClosure(proto)
 	self.stack.push(closure)
 	for i, uvInfo := range proto.Upvalues {
@@ -1 +1,726 @@
 package state
+
+import (
+	"api"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"stdlib"
+	"strings"
+)
+
+// size of the first and second parts of a traceback
+const (
+	LEVELS1 = 10
+	LEVELS2 = 11
+)
+
+// [-0, +0, v]
+// http://www.lua.org/manual/5.3/manual.html#luaL_error
+func (self *luaState) Error2(fmt string, a ...interface{}) int {
+	self.Where(1)
+	self.PushFString(fmt, a...)
+	self.Concat(2)
+	return self.Error()
+}
+
+// [-0, +0, v]
+// http://www.lua.org/manual/5.3/manual.html#luaL_argerror
+func (self *luaState) ArgError(arg int, extraMsg string) int {
+	frame := self.getFrame(0)
+	if frame == nil { // no stack frame?
+		return self.Error2("bad argument #%d (%s)", arg, extraMsg)
+	}
+	kind, name := frame.funcName()
+	if kind == "method" {
+		arg--         // do not count 'self'
+		if arg == 0 { // error is in the self argument itself?
+			return self.Error2("calling '%s' on bad self (%s)", name, extraMsg)
+		}
+	}
+	if name == "" {
+		var ok bool
+		if name, ok = self.globalFuncName(frame); !ok {
+			name = "?"
+		}
+	}
+	return self.Error2("bad argument #%d to '%s' (%s)", arg, name, extraMsg)
+}
+
+// [-0, +1, m]
+// http://www.lua.org/manual/5.3/manual.html#luaL_where
+func (self *luaState) Where(lvl int) {
+	if frame := self.getFrame(lvl); frame != nil {
+		if line := frame.currentLine(); line > 0 {
+			self.PushFString("%s:%d: ", frame.shortSrc(), line)
+			return
+		}
+	}
+	self.PushString("") // else, no information available...
+}
+
+// [-0, +0, v]
+// http://www.lua.org/manual/5.3/manual.html#luaL_checkstack
+func (self *luaState) CheckStack2(sz int, msg string) {
+	if !self.CheckStack(sz) {
+		if msg != "" {
+			self.Error2("stack overflow (%s)", msg)
+		} else {
+			self.Error2("stack overflow")
+		}
+	}
+}
+
+// [-0, +0, v]
+// http://www.lua.org/manual/5.3/manual.html#luaL_argcheck
+func (self *luaState) ArgCheck(cond bool, arg int, extraMsg string) {
+	if !cond {
+		self.ArgError(arg, extraMsg)
+	}
+}
+
+// [-0, +0, v]
+// http://www.lua.org/manual/5.3/manual.html#luaL_checkany
+func (self *luaState) CheckAny(arg int) {
+	if self.Type(arg) == api.LUA_TNONE {
+		self.ArgError(arg, "value expected")
+	}
+}
+
+// [-0, +0, v]
+// http://www.lua.org/manual/5.3/manual.html#luaL_checktype
+func (self *luaState) CheckType(arg int, t api.LuaType) {
+	if self.Type(arg) != t {
+		self.tagError(arg, t)
+	}
+}
+
+// [-0, +0, v]
+// http://www.lua.org/manual/5.3/manual.html#luaL_checkinteger
+func (self *luaState) CheckInteger(arg int) int64 {
+	i, ok := self.ToIntegerX(arg)
+	if !ok {
+		self.intError(arg)
+	}
+	return i
+}
+
+// [-0, +0, v]
+// http://www.lua.org/manual/5.3/manual.html#luaL_checknumber
+func (self *luaState) CheckNumber(arg int) float64 {
+	f, ok := self.ToNumberX(arg)
+	if !ok {
+		self.tagError(arg, api.LUA_TNUMBER)
+	}
+	return f
+}
+
+// [-0, +0, v]
+// http://www.lua.org/manual/5.3/manual.html#luaL_checkstring
+func (self *luaState) CheckString(arg int) string {
+	s, ok := self.ToStringX(arg)
+	if !ok {
+		self.tagError(arg, api.LUA_TSTRING)
+	}
+	return s
+}
+
+// [-0, +0, v]
+// http://www.lua.org/manual/5.3/manual.html#luaL_checkoption
+// an empty def means the argument is required
+func (self *luaState) CheckOption(arg int, def string, lst []string) int {
+	var name string
+	if def != "" {
+		name = self.OptString(arg, def)
+	} else {
+		name = self.CheckString(arg)
+	}
+	for i, opt := range lst {
+		if opt == name {
+			return i
+		}
+	}
+	return self.ArgError(arg, self.PushFString("invalid option '%s'", name))
+}
+
+// [-0, +0, v]
+// http://www.lua.org/manual/5.3/manual.html#luaL_optinteger
+func (self *luaState) OptInteger(arg int, def int64) int64 {
+	if self.IsNoneOrNil(arg) {
+		return def
+	}
+	return self.CheckInteger(arg)
+}
+
+// [-0, +0, v]
+// http://www.lua.org/manual/5.3/manual.html#luaL_optnumber
+func (self *luaState) OptNumber(arg int, def float64) float64 {
+	if self.IsNoneOrNil(arg) {
+		return def
+	}
+	return self.CheckNumber(arg)
+}
+
+// [-0, +0, v]
+// http://www.lua.org/manual/5.3/manual.html#luaL_optstring
+func (self *luaState) OptString(arg int, def string) string {
+	if self.IsNoneOrNil(arg) {
+		return def
+	}
+	return self.CheckString(arg)
+}
+
+// [-0, +0, m]
+// http://www.lua.org/manual/5.3/manual.html#luaL_testudata
+func (self *luaState) TestUData(arg int, tname string) interface{} {
+	if self.Type(arg) != api.LUA_TUSERDATA {
+		return nil // value is not a userdata
+	}
+	p := self.ToUserData(arg)
+	if self.GetMetatable(arg) { // does it have a metatable?
+		self.GetMetatable2(tname)   // get correct metatable
+		if !self.RawEqual(-1, -2) { // not the same?
+			p = nil // value is a userdata with wrong metatable
+		}
+		self.Pop(2) // remove both metatables
+		return p
+	}
+	return nil
+}
+
+// [-0, +0, v]
+// http://www.lua.org/manual/5.3/manual.html#luaL_checkudata
+func (self *luaState) CheckUData(arg int, tname string) interface{} {
+	p := self.TestUData(arg, tname)
+	if p == nil {
+		self.typeError(arg, tname)
+	}
+	return p
+}
+
+// [-0, +?, e]
+// http://www.lua.org/manual/5.3/manual.html#luaL_dofile
+func (self *luaState) DoFile(filename string) bool {
+	return self.LoadFile(filename) != api.LUA_OK ||
+		self.PCall(0, api.LUA_MULTRET, 0) != api.LUA_OK
+}
+
+// [-0, +?, –]
+// http://www.lua.org/manual/5.3/manual.html#luaL_dostring
+func (self *luaState) DoString(str string) bool {
+	return self.LoadString(str) != api.LUA_OK ||
+		self.PCall(0, api.LUA_MULTRET, 0) != api.LUA_OK
+}
+
+// [-0, +1, m]
+// http://www.lua.org/manual/5.3/manual.html#luaL_loadfile
+func (self *luaState) LoadFile(filename string) api.ThreadStatus {
+	return self.LoadFileX(filename, "bt")
+}
+
+// [-0, +1, m]
+// http://www.lua.org/manual/5.3/manual.html#luaL_loadfilex
+func (self *luaState) LoadFileX(filename, mode string) api.ThreadStatus {
+	return self.LoadFileEnv(filename, mode, 0)
+}
+
+// [-0, +1, m]
+// like LoadFileX, but the loaded function gets the value at index env as
+// its _ENV, see LoadEnv
+func (self *luaState) LoadFileEnv(filename, mode string, env int) api.ThreadStatus {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return self.errFile(filename, err)
+	}
+	if len(data) > 0 && data[0] == '#' { // skip first line if it is a comment
+		if i := strings.IndexByte(string(data), '\n'); i >= 0 {
+			data = data[i:]
+		} else {
+			data = nil
+		}
+	}
+	return self.LoadEnv(data, "@"+filename, mode, env)
+}
+
+// lua-5.3.4/src/lauxlib.c#errfile
+func (self *luaState) errFile(filename string, err error) api.ThreadStatus {
+	what := "open"
+	if pe, ok := err.(*os.PathError); ok {
+		if pe.Op == "read" {
+			what = "read"
+		}
+		err = pe.Err // the reason only, like strerror
+	}
+	self.PushFString("cannot %s %s: %s", what, filename, err.Error())
+	return api.LUA_ERRFILE
+}
+
+// [-0, +1, –]
+// http://www.lua.org/manual/5.3/manual.html#luaL_loadstring
+func (self *luaState) LoadString(s string) api.ThreadStatus {
+	return self.Load([]byte(s), s, "bt")
+}
+
+// [-(nargs+1), +(nresults|0), –]
+// calls a function in protected mode like PCall, but returns the error
+// as a *api.LuaError carrying the traceback taken where it was raised
+func (self *luaState) CallE(nArgs, nResults int) error {
+	var traceback string
+	base := self.GetTop() - nArgs // function index
+	self.PushGoFunction(func(ls api.LuaState) int {
+		ls.Traceback(ls, "", 1)
+		traceback = ls.ToString(-1)
+		ls.Pop(1)
+		return 1 // error object is left untouched
+	})
+	self.Insert(base) // put it under function and args
+	g := self.global
+	outerCause := g.interruptCause // CallE may run inside another one
+	g.interruptCause = nil
+	status := self.PCall(nArgs, nResults, base)
+	self.Remove(base) // remove message handler from the stack
+	cause := g.interruptCause
+	if cause == nil {
+		g.interruptCause = outerCause
+	}
+	if status != api.LUA_OK {
+		err := self.popError(status, traceback)
+		err.Err = cause
+		return err
+	}
+	return nil
+}
+
+// [-(nargs+1), +(nresults|0), –]
+// like CallE, but with ctx attached to the state for the duration of the
+// call (see SetContext); when the call is interrupted the returned error
+// wraps ctx.Err()
+func (self *luaState) CallContext(ctx context.Context, nArgs, nResults int) error {
+	old := self.global.ctx
+	self.SetContext(ctx)
+	defer self.SetContext(old)
+	return self.CallE(nArgs, nResults)
+}
+
+// [-0, +?, –]
+// like DoFile, but returns the error as a *api.LuaError
+func (self *luaState) DoFileE(filename string) error {
+	if err := self.LoadFileE(filename); err != nil {
+		return err
+	}
+	return self.CallE(0, api.LUA_MULTRET)
+}
+
+// [-0, +?, –]
+// like DoString, but returns the error as a *api.LuaError
+func (self *luaState) DoStringE(str string) error {
+	if err := self.LoadStringE(str); err != nil {
+		return err
+	}
+	return self.CallE(0, api.LUA_MULTRET)
+}
+
+// [-0, +(1|0), m]
+// like LoadFile, but returns the error as a *api.LuaError
+func (self *luaState) LoadFileE(filename string) error {
+	if status := self.LoadFile(filename); status != api.LUA_OK {
+		return self.popError(status, "")
+	}
+	return nil
+}
+
+// [-0, +(1|0), –]
+// like LoadString, but returns the error as a *api.LuaError
+func (self *luaState) LoadStringE(s string) error {
+	if status := self.LoadString(s); status != api.LUA_OK {
+		return self.popError(status, "")
+	}
+	return nil
+}
+
+// pops the error object left by a failed load or call
+func (self *luaState) popError(status int, traceback string) *api.LuaError {
+	err := &api.LuaError{
+		Status:    status,
+		Value:     self.stack.get(-1),
+		Traceback: traceback,
+	}
+	if msg, ok := self.ToStringX(-1); ok {
+		err.Message = msg
+	} else {
+		err.Message = fmt.Sprintf("(error object is a %s value)", self.TypeName2(-1))
+	}
+	self.Pop(1)
+	return err
+}
+
+// [-0, +0, v]
+// http://www.lua.org/manual/5.3/manual.html#luaL_checkversion
+func (self *luaState) CheckVersion() {
+	// core and auxiliary library are always built together, nothing to check
+}
+
+// [-0, +0, –]
+// http://www.lua.org/manual/5.3/manual.html#luaL_typename
+func (self *luaState) TypeName2(idx int) string {
+	return self.TypeName(self.Type(idx))
+}
+
+// [-0, +1, e]
+// http://www.lua.org/manual/5.3/manual.html#luaL_tolstring
+func (self *luaState) ToString2(idx int) string {
+	if self.CallMeta(idx, "__tostring") { // metafield?
+		if !self.IsString(-1) {
+			self.Error2("'__tostring' must return a string")
+		}
+	} else {
+		switch self.Type(idx) {
+		case api.LUA_TNUMBER:
+			self.PushValue(idx)
+			self.ToString(-1)
+		case api.LUA_TSTRING:
+			self.PushValue(idx)
+		case api.LUA_TBOOLEAN:
+			if self.ToBoolean(idx) {
+				self.PushString("true")
+			} else {
+				self.PushString("false")
+			}
+		case api.LUA_TNIL:
+			self.PushString("nil")
+		default:
+			tt := self.GetMetafield(idx, "__name") // try name
+			kind := self.TypeName2(idx)
+			if tt == api.LUA_TSTRING {
+				kind = self.ToString(-1)
+			}
+			if self.global.deterministic { // addresses change from run to run
+				self.PushFString("%s: 0x%08x", kind, self.objectID(idx))
+			} else {
+				self.PushFString("%s: %p", kind, self.ToPointer(idx))
+			}
+			if tt != api.LUA_TNIL {
+				self.Remove(-2) // remove '__name'
+			}
+		}
+	}
+	return self.ToString(-1)
+}
+
+// [-0, +0, e]
+// http://www.lua.org/manual/5.3/manual.html#luaL_len
+func (self *luaState) Len2(idx int) int64 {
+	self.Len(idx)
+	i, isNum := self.ToIntegerX(-1)
+	if !isNum {
+		self.Error2("object length is not an integer")
+	}
+	self.Pop(1)
+	return i
+}
+
+// [-0, +1, e]
+// http://www.lua.org/manual/5.3/manual.html#luaL_getsubtable
+func (self *luaState) GetSubTable(idx int, fname string) bool {
+	if self.GetField(idx, fname) == api.LUA_TTABLE {
+		return true // table already there
+	}
+	self.Pop(1) // remove previous result
+	idx = self.stack.absIndex(idx)
+	self.NewTable()
+	self.PushValue(-1)        // copy to be left at top
+	self.SetField(idx, fname) // assign new table to field
+	return false              // false, because did not find table there
+}
+
+// [-0, +0, –]
+// http://www.lua.org/manual/5.3/manual.html#luaL_buffinit
+func (self *luaState) NewBuffer() api.Buffer {
+	return &buffer{ls: self}
+}
+
+// index of free-list header
+const FREELIST = 0
+
+// [-1, +0, m]
+// http://www.lua.org/manual/5.3/manual.html#luaL_ref
+func (self *luaState) Ref(t int) int {
+	if self.IsNil(-1) {
+		self.Pop(1)           // remove it from stack
+		return api.LUA_REFNIL // 'nil' has a unique fixed reference
+	}
+	t = self.AbsIndex(t)
+	self.RawGetI(t, FREELIST)      // get first free element
+	ref := int(self.ToInteger(-1)) // ref = t[freelist]
+	self.Pop(1)                    // remove it from stack
+	if ref != 0 {                  // any free element?
+		self.RawGetI(t, int64(ref)) // remove it from list
+		self.RawSetI(t, FREELIST)   // (t[freelist] = t[ref])
+	} else { // no free elements
+		ref = int(self.RawLen(t)) + 1 // get a new reference
+	}
+	self.RawSetI(t, int64(ref))
+	return ref
+}
+
+// [-0, +0, –]
+// http://www.lua.org/manual/5.3/manual.html#luaL_unref
+func (self *luaState) Unref(t, ref int) {
+	if ref >= 0 {
+		t = self.AbsIndex(t)
+		self.RawGetI(t, FREELIST)
+		self.RawSetI(t, int64(ref)) // t[ref] = t[freelist]
+		self.PushInteger(int64(ref))
+		self.RawSetI(t, FREELIST) // t[freelist] = ref
+	}
+}
+
+// [-0, +(0|1), m]
+// http://www.lua.org/manual/5.3/manual.html#luaL_getmetafield
+func (self *luaState) GetMetafield(obj int, event string) api.LuaType {
+	if !self.GetMetatable(obj) { // no metatable?
+		return api.LUA_TNIL
+	}
+	self.PushString(event)
+	tt := self.RawGet(-2)
+	if tt == api.LUA_TNIL { // is metafield nil?
+		self.Pop(2) // remove metatable and metafield
+	} else {
+		self.Remove(-2) // remove only metatable
+	}
+	return tt // return metafield type
+}
+
+// [-0, +1, m]
+// http://www.lua.org/manual/5.3/manual.html#luaL_newmetatable
+func (self *luaState) NewMetatable(tname string) bool {
+	if self.GetMetatable2(tname) != api.LUA_TNIL {
+		return false // leave previous value on top, but return false
+	}
+	self.Pop(1)
+	self.CreateTable(0, 2) // create metatable
+	self.PushString(tname)
+	self.SetField(-2, "__name") // metatable.__name = tname
+	self.PushValue(-1)
+	self.SetField(api.LUA_REGISTRYINDEX, tname) // registry.name = metatable
+	return true
+}
+
+// [-0, +1, –]
+// http://www.lua.org/manual/5.3/manual.html#luaL_getmetatable
+func (self *luaState) GetMetatable2(tname string) api.LuaType {
+	return self.GetField(api.LUA_REGISTRYINDEX, tname)
+}
+
+// [-0, +0, –]
+// http://www.lua.org/manual/5.3/manual.html#luaL_setmetatable
+func (self *luaState) SetMetatable2(tname string) {
+	self.GetMetatable2(tname)
+	self.SetMetatable(-2)
+}
+
+// [-0, +(0|1), e]
+// http://www.lua.org/manual/5.3/manual.html#luaL_callmeta
+func (self *luaState) CallMeta(obj int, event string) bool {
+	obj = self.AbsIndex(obj)
+	if self.GetMetafield(obj, event) == api.LUA_TNIL { // no metafield?
+		return false
+	}
+	self.PushValue(obj)
+	self.Call(1, 1)
+	return true
+}
+
+// [-0, +0, e]
+// http://www.lua.org/manual/5.3/manual.html#luaL_openlibs
+func (self *luaState) OpenLibs() {
+	libs := []struct {
+		name string
+		open api.GoFunction
+	}{
+		{"_G", stdlib.OpenBaseLib},
+		{"coroutine", stdlib.OpenCoroutineLib},
+		{"debug", stdlib.OpenDebugLib},
+		{"math", stdlib.OpenMathLib},
+		{"os", stdlib.OpenOSLib},
+		{"string", stdlib.OpenStringLib},
+	}
+	for _, lib := range libs {
+		self.RequireF(lib.name, lib.open, true)
+		self.Pop(1)
+	}
+}
+
+// [-0, +1, e]
+// http://www.lua.org/manual/5.3/manual.html#luaL_requiref
+func (self *luaState) RequireF(modname string, openf api.GoFunction, glb bool) {
+	self.GetSubTable(api.LUA_REGISTRYINDEX, api.LUA_LOADED_TABLE)
+	self.GetField(-1, modname) // LOADED[modname]
+	if !self.ToBoolean(-1) {   // package not already loaded?
+		self.Pop(1) // remove field
+		self.PushGoFunction(openf)
+		self.PushString(modname)   // argument to open function
+		self.Call(1, 1)            // call 'openf' to open module
+		self.PushValue(-1)         // make copy of module (call result)
+		self.SetField(-3, modname) // _LOADED[modname] = module
+	}
+	self.Remove(-2) // remove _LOADED table
+	if glb {
+		self.PushValue(-1)      // copy of module
+		self.SetGlobal(modname) // _G[modname] = module
+	}
+}
+
+// [-0, +1, m]
+// http://www.lua.org/manual/5.3/manual.html#luaL_newlib
+func (self *luaState) NewLib(l api.FuncReg) {
+	self.NewLibTable(l)
+	self.SetFuncs(l, 0)
+}
+
+// [-0, +1, m]
+// http://www.lua.org/manual/5.3/manual.html#luaL_newlibtable
+func (self *luaState) NewLibTable(l api.FuncReg) {
+	self.CreateTable(0, len(l))
+}
+
+// [-nup, +0, m]
+// http://www.lua.org/manual/5.3/manual.html#luaL_setfuncs
+func (self *luaState) SetFuncs(l api.FuncReg, nup int) {
+	self.CheckStack2(nup, "too many upvalues")
+	names := make([]string, 0, len(l))
+	for name := range l {
+		names = append(names, name)
+	}
+	// same order of insertion, hence of traversal, on every run
+	sort.Strings(names)
+	for _, name := range names { // fill the table with given functions
+		fun := l[name]
+		for i := 0; i < nup; i++ { // copy upvalues to the top
+			self.PushValue(-nup)
+		}
+		// r[-(nup+2)][name]=fun
+		self.PushGoClosure(fun, nup) // closure with those upvalues
+		self.SetField(-(nup + 2), name)
+	}
+	self.Pop(nup) // remove upvalues
+}
+
+// [-0, +1, m]
+// http://www.lua.org/manual/5.3/manual.html#luaL_traceback
+func (self *luaState) Traceback(ls1 api.LuaState, msg string, level int) {
+	l1 := ls1.(*luaState)
+	var buf strings.Builder
+	last := l1.lastLevel()
+	n1 := -1
+	if last-level > LEVELS1+LEVELS2 {
+		n1 = LEVELS1
+	}
+	if msg != "" {
+		buf.WriteString(msg)
+		buf.WriteString("\n")
+	}
+	buf.WriteString("stack traceback:")
+	for frame := l1.getFrame(level); frame != nil; frame = l1.getFrame(level) {
+		level++
+		if n1 == 0 { // too many levels?
+			buf.WriteString("\n\t...")
+			level = last - LEVELS2 + 1 // and skip to last ones
+		} else {
+			buf.WriteString("\n\t")
+			buf.WriteString(frame.shortSrc())
+			buf.WriteString(":")
+			if line := frame.currentLine(); line > 0 {
+				buf.WriteString(fmt.Sprintf("%d:", line))
+			}
+			buf.WriteString(" in ")
+			buf.WriteString(self.pushFuncName(frame))
+			if frame.isTailCall {
+				buf.WriteString("\n\t(...tail calls...)")
+			}
+		}
+		n1--
+	}
+	self.PushString(buf.String())
+}
+
+// lua-5.3.4/src/lauxlib.c#lastlevel
+// level of the outermost active function
+func (self *luaState) lastLevel() int {
+	level := 0
+	for self.getFrame(level+1) != nil {
+		level++
+	}
+	return level
+}
+
+// lua-5.3.4/src/lauxlib.c#pushfuncname
+func (self *luaState) pushFuncName(frame *luaStack) string {
+	if name, ok := self.globalFuncName(frame); ok { // try first a global name
+		return fmt.Sprintf("function '%s'", name)
+	}
+	if kind, name := frame.funcName(); kind != "" { // is there a name from code?
+		return fmt.Sprintf("%s '%s'", kind, name)
+	}
+	if !frame.isLua() { // nothing left...
+		return "?"
+	}
+	if proto := frame.closure.proto; proto.LineDefined == 0 { // main?
+		return "main chunk"
+	} else {
+		return fmt.Sprintf("function <%s:%d>", frame.shortSrc(), proto.LineDefined)
+	}
+}
+
+func (self *luaState) intError(arg int) {
+	if self.IsNumber(arg) {
+		self.ArgError(arg, "number has no integer representation")
+	} else {
+		self.tagError(arg, api.LUA_TNUMBER)
+	}
+}
+
+func (self *luaState) tagError(arg int, tag api.LuaType) {
+	self.typeError(arg, self.TypeName(tag))
+}
+
+// lua-5.3.4/src/lauxlib.c#luaL_typeerror
+func (self *luaState) typeError(arg int, tname string) int {
+	var typeArg string // name for the type of the actual argument
+	if self.GetMetafield(arg, "__name") == api.LUA_TSTRING {
+		typeArg = self.ToString(-1) // use the given type name
+	} else if self.Type(arg) == api.LUA_TLIGHTUSERDATA {
+		typeArg = "light userdata" // special name for messages
+	} else {
+		typeArg = self.TypeName2(arg) // standard name
+	}
+	return self.ArgError(arg, tname+" expected, got "+typeArg)
+}
+
+// lua-5.3.4/src/lauxlib.c#pushglobalfuncname
+// searches package.loaded for the function running in the given frame and
+// returns its qualified name, such as "string.format" or "print"
+func (self *luaState) globalFuncName(frame *luaStack) (string, bool) {
+	loaded, ok := self.registry.get(api.LUA_LOADED_TABLE).(*luaTable)
+	if !ok {
+		return "", false
+	}
+	for modname, m, _ := loaded.next(nil); modname != nil; modname, m, _ = loaded.next(modname) {
+		mod, ok := m.(*luaTable)
+		if !ok {
+			continue
+		}
+		for k, v, _ := mod.next(nil); k != nil; k, v, _ = mod.next(k) {
+			name, ok := k.(string)
+			if ok && v == frame.closure {
+				if modname == "_G" {
+					return name, true
+				}
+				return fmt.Sprintf("%v.%s", modname, name), true
+			}
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,92 @@
+package state
+
+import (
+	"api"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRefFreeList(t *testing.T) {
+	ls := New()
+	ls.NewTable() // references go into the table at index 1
+	ref := func(s string) int {
+		ls.PushString(s)
+		return ls.Ref(1)
+	}
+	ls.PushNil()
+	if r := ls.Ref(1); r != api.LUA_REFNIL || ls.GetTop() != 1 {
+		t.Fatalf("nil got ref %d, top %d", r, ls.GetTop())
+	}
+	if a, b, c := ref("a"), ref("b"), ref("c"); a != 1 || b != 2 || c != 3 {
+		t.Fatalf("got refs %d %d %d, want 1 2 3", a, b, c)
+	}
+	ls.Unref(1, 2)
+	if ls.RawGetI(1, FREELIST); ls.ToInteger(-1) != 2 {
+		t.Fatalf("free list starts at %v, want 2", ls.ToInteger(-1))
+	}
+	ls.Pop(1)
+	if r := ref("d"); r != 2 {
+		t.Fatalf("got ref %d, want the freed 2", r)
+	}
+	if r := ref("e"); r != 4 {
+		t.Fatalf("got ref %d, want a new 4", r)
+	}
+	// freed references are reused last freed first
+	ls.Unref(1, 1)
+	ls.Unref(1, 3)
+	if r1, r2, r3 := ref("f"), ref("g"), ref("h"); r1 != 3 || r2 != 1 || r3 != 5 {
+		t.Fatalf("got refs %d %d %d, want 3 1 5", r1, r2, r3)
+	}
+	ls.Unref(1, api.LUA_REFNIL) // no-ops
+	ls.Unref(1, api.LUA_NOREF)
+	for ref, want := range map[int64]string{1: "g", 2: "d", 3: "f", 4: "e", 5: "h"} {
+		ls.RawGetI(1, ref)
+		if got := ls.ToString(-1); got != want {
+			t.Errorf("t[%d] = %q, want %q", ref, got, want)
+		}
+		ls.Pop(1)
+	}
+}
+
+func TestRegistryRef(t *testing.T) {
+	ls := New()
+	ls.PushString("kept")
+	r := api.NewRef(ls)
+	if ls.GetTop() != 0 {
+		t.Fatal("value left on the stack")
+	}
+	r.Push(ls)
+	if ls.ToString(-1) != "kept" {
+		t.Fatalf("got %q", ls.ToString(-1))
+	}
+	r.Release()
+	r.Release() // harmless
+	if r.Push(ls); !ls.IsNil(-1) || r.Value() != api.LUA_NOREF {
+		t.Fatal("released reference still holds its value")
+	}
+}
+
+func TestLoadFileErrors(t *testing.T) {
+	ls := New()
+	dir, err := ioutil.TempDir("", "lua")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	missing := filepath.Join(dir, "missing.lua")
+	cases := []struct{ filename, msg string }{
+		{missing, "cannot open " + missing + ": no such file or directory"},
+		{dir, "cannot read " + dir + ": is a directory"},
+	}
+	for _, c := range cases {
+		if status := ls.LoadFile(c.filename); status != api.LUA_ERRFILE {
+			t.Errorf("%s: got status %d, want LUA_ERRFILE", c.filename, status)
+		}
+		if msg, _ := ls.ToStringX(-1); msg != c.msg {
+			t.Errorf("got %q, want %q", msg, c.msg)
+		}
+		ls.Pop(1)
+	}
+}
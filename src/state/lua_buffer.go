@@ -0,0 +1,47 @@
+package state
+
+import "strings"
+
+// lua-5.3.4/src/lauxlib.c#luaL_Buffer
+type buffer struct {
+	ls *luaState
+	b  strings.Builder
+}
+
+// lua-5.3.4/src/lauxlib.c#luaL_prepbuffsize
+// accounts for the bytes of a larger builder before it is allocated
+func (self *buffer) Grow(n int) {
+	if free := self.b.Cap() - self.b.Len(); n > free {
+		newCap := 2 * self.b.Cap()   // double buffer size
+		if newCap-self.b.Len() < n { // not big enough?
+			newCap = self.b.Len() + n
+		}
+		self.ls.allocate(newCap - self.b.Cap())
+		self.b.Grow(newCap - self.b.Len())
+	}
+}
+
+func (self *buffer) Write(p []byte) (int, error) {
+	self.Grow(len(p))
+	return self.b.Write(p)
+}
+
+func (self *buffer) WriteByte(c byte) error {
+	self.Grow(1)
+	return self.b.WriteByte(c)
+}
+
+func (self *buffer) WriteString(s string) (int, error) {
+	self.Grow(len(s))
+	return self.b.WriteString(s)
+}
+
+func (self *buffer) Len() int {
+	return self.b.Len()
+}
+
+// lua-5.3.4/src/lauxlib.c#luaL_pushresult
+// the string takes the bytes of the builder, already accounted for
+func (self *buffer) PushResult() {
+	self.ls.stack.push(self.b.String())
+}
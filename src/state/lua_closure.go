@@ -13,6 +13,7 @@ type closure struct {
 	proto  *binchunk.Prototype
 	goFunc api.GoFunction
 	upvals []*upvalue
+	gcObject
 }
 
 func newGoClosure(f api.GoFunction, nUpvals int) *closure {
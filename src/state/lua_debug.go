@@ -0,0 +1,584 @@
+package state
+
+import (
+	"api"
+	"binchunk"
+	"fmt"
+	"strings"
+	"vm"
+)
+
+const LUA_IDSIZE = 60 // size of chunk ids used in messages
+
+// getFrame returns the activation record at the given level: level 0 is the
+// running function, level 1 is the function that called it, and so on.
+func (self *luaState) getFrame(level int) *luaStack {
+	stack := self.stack
+	for ; level > 0 && stack != nil; level-- {
+		stack = stack.prev
+	}
+	if stack == nil || stack.closure == nil {
+		return nil
+	}
+	return stack
+}
+
+func (self *luaStack) isLua() bool {
+	return self.closure != nil && self.closure.proto != nil
+}
+
+// line of the instruction being executed by a Lua frame, -1 if unknown
+func (self *luaStack) currentLine() int {
+	if !self.isLua() {
+		return -1
+	}
+	lineInfo := self.closure.proto.LineInfo
+	if pc := self.pc - 1; pc >= 0 && pc < len(lineInfo) {
+		return int(lineInfo[pc])
+	} else if len(lineInfo) > 0 {
+		return int(lineInfo[0])
+	}
+	return -1
+}
+
+// lua-5.3.4/src/ldebug.h#getfuncline
+func funcLine(proto *binchunk.Prototype, pc int) int {
+	if pc >= 0 && pc < len(proto.LineInfo) {
+		return int(proto.LineInfo[pc])
+	}
+	return -1
+}
+
+func (self *luaStack) shortSrc() string {
+	if !self.isLua() {
+		return "[C]"
+	}
+	return chunkID(self.closure.proto.Source)
+}
+
+// lua-5.3.4/src/ldebug.c#auxgetinfo
+// fills the fields of ar selected by what, for closure c running in frame
+// (nil for functions that are not running)
+func auxGetInfo(what string, ar *api.Debug, c *closure, frame *luaStack) bool {
+	status := true
+	for _, opt := range what {
+		switch opt {
+		case 'S':
+			funcInfo(ar, c)
+		case 'l':
+			ar.CurrentLine = -1
+			if frame != nil && frame.isLua() {
+				ar.CurrentLine = frame.currentLine()
+			}
+		case 'u':
+			ar.NUps, ar.NParams, ar.IsVararg = 0, 0, true
+			if c != nil {
+				ar.NUps = len(c.upvals)
+				if c.proto != nil {
+					ar.NParams = int(c.proto.NumParams)
+					ar.IsVararg = c.proto.IsVararg == 1
+				}
+			}
+		case 't':
+			ar.IsTailCall = frame != nil && frame.isTailCall
+		case 'n':
+			ar.NameWhat, ar.Name = "", ""
+			if frame != nil {
+				ar.NameWhat, ar.Name = frame.funcName()
+			}
+		case 'L', 'f': // handled by GetInfo
+		default: // invalid option
+			status = false
+		}
+	}
+	return status
+}
+
+// lua-5.3.4/src/ldebug.c#funcinfo
+func funcInfo(ar *api.Debug, c *closure) {
+	if c == nil || c.proto == nil {
+		ar.Source = "=[C]"
+		ar.LineDefined = -1
+		ar.LastLineDefined = -1
+		ar.What = "C"
+	} else {
+		proto := c.proto
+		ar.Source = proto.Source
+		if ar.Source == "" {
+			ar.Source = "=?"
+		}
+		ar.LineDefined = int(proto.LineDefined)
+		ar.LastLineDefined = int(proto.LastLineDefined)
+		if ar.LineDefined == 0 {
+			ar.What = "main"
+		} else {
+			ar.What = "Lua"
+		}
+	}
+	ar.ShortSrc = chunkID(ar.Source)
+}
+
+// lua-5.3.4/src/ldebug.c#collectvalidlines
+// returns a table whose keys are the lines with code of c, nil for Go functions
+func collectValidLines(c *closure) luaValue {
+	if c == nil || c.proto == nil {
+		return nil
+	}
+	t := newLuaTable(0, len(c.proto.LineInfo))
+	for _, line := range c.proto.LineInfo {
+		t.set(int64(line), true)
+	}
+	return t
+}
+
+// lua-5.3.4/src/ldebug.c#findlocal
+// returns the name of the n-th local of frame and the slot holding it
+func findLocal(frame *luaStack, n int) (string, *luaValue) {
+	name := ""
+	if frame.isLua() {
+		if n < 0 { // access to vararg values?
+			return findVararg(frame, -n)
+		}
+		name = getLocalName(frame.closure.proto, n, frame.pc-1)
+	}
+	if name == "" { // no 'standard' name?
+		if n > 0 && n <= frame.top { // is 'n' inside the frame's stack?
+			name = "(*temporary)" // generic name for any valid slot
+		} else {
+			return "", nil // no name
+		}
+	}
+	return name, &frame.slots[n-1]
+}
+
+// lua-5.3.4/src/ldebug.c#findvararg
+func findVararg(frame *luaStack, n int) (string, *luaValue) {
+	if n > len(frame.varargs) {
+		return "", nil // no such vararg
+	}
+	return "(*vararg)", &frame.varargs[n-1]
+}
+
+// lua-5.3.4/src/lapi.c#aux_upvalue
+// returns the name of the n-th upvalue of fn and the cell holding it, which
+// may still be empty
+func auxUpvalue(fn luaValue, n int) (string, **upvalue, bool) {
+	c, ok := fn.(*closure)
+	if !ok || n < 1 || n > len(c.upvals) {
+		return "", nil, false // not a closure or no such upvalue
+	}
+	uv := &c.upvals[n-1]
+	if c.proto == nil { // Go closure
+		return "", uv, true
+	}
+	if n <= len(c.proto.UpvalueNames) && c.proto.UpvalueNames[n-1] != "" {
+		return c.proto.UpvalueNames[n-1], uv, true
+	}
+	return "(*no name)", uv, true
+}
+
+// lua-5.3.4/src/ldo.c#luaD_hook
+// calls the hook in the running frame, which gets room for LUA_MINSATCK
+// values and has its top restored afterwards
+func (self *luaState) callHook(event, line int) {
+	if self.hook == nil || self.inHook {
+		return
+	}
+	frame := self.stack
+	top := frame.top
+	frame.check(api.LUA_MINSATCK)
+	ar := &api.Debug{Event: event, CurrentLine: line, CallInfo: frame}
+	self.inHook = true // cannot call hooks inside a hook
+	defer func() { self.inHook = false }()
+	// inHook is reset by the deferred call even if the hook raises an
+	// error; isHooked is not, so that the message handler still sees the
+	// frame as running a hook (the frame is discarded afterwards)
+	frame.isHooked = true
+	self.hook(self, ar)
+	frame.isHooked = false
+	for frame.top > top {
+		frame.pop()
+	}
+}
+
+// lua-5.3.4/src/ldo.c#luaD_poscall
+// runs the return hook of the running frame, about to be popped
+func (self *luaState) retHook() {
+	if self.hookMask&api.LUA_MASKRET != 0 {
+		self.callHook(api.LUA_HOOKRET, -1)
+	}
+	if prev := self.stack.prev; prev != nil && prev.isLua() {
+		self.oldPC = prev.pc // 'oldPC' for caller function
+	}
+}
+
+// lua-5.3.4/src/ldebug.c#luaG_traceexec
+// called before each instruction while line or count hooks are set
+func (self *luaState) traceExec() {
+	mask := self.hookMask
+	self.hookCount--
+	countHook := self.hookCount == 0 && mask&api.LUA_MASKCOUNT != 0
+	if countHook {
+		self.hookCount = self.baseHookCount // reset count
+	} else if mask&api.LUA_MASKLINE == 0 {
+		return // no line hook and count != 0; nothing to be done
+	}
+	if countHook {
+		self.callHook(api.LUA_HOOKCOUNT, -1)
+	}
+	frame := self.stack
+	if mask&api.LUA_MASKLINE != 0 {
+		proto := frame.closure.proto
+		npc := frame.pc - 1
+		newLine := funcLine(proto, npc)
+		if npc == 0 || // call linehook when enter a new function,
+			frame.pc <= self.oldPC || // when jump back (loop), or when
+			newLine != funcLine(proto, self.oldPC-1) { // enter a new line
+			self.callHook(api.LUA_HOOKLINE, newLine)
+		}
+	}
+	self.oldPC = frame.pc
+}
+
+// lua-5.3.4/src/lobject.c#luaO_chunkid
+func chunkID(source string) string {
+	const pre, rets, pos = `[string "`, "...", `"]`
+	l := len(source)
+	if strings.HasPrefix(source, "=") { // 'literal' source
+		if l <= LUA_IDSIZE {
+			return source[1:]
+		}
+		return source[1:LUA_IDSIZE]
+	}
+	if strings.HasPrefix(source, "@") { // file name
+		if l <= LUA_IDSIZE {
+			return source[1:]
+		}
+		return rets + source[l-(LUA_IDSIZE-len(rets)-1):]
+	}
+	// string; format as [string "source"]
+	bufflen := LUA_IDSIZE - len(pre+rets+pos) - 1
+	nl := strings.IndexByte(source, '\n')
+	if l < bufflen && nl < 0 {
+		return pre + source + pos
+	}
+	if nl >= 0 {
+		source = source[:nl]
+	}
+	if len(source) > bufflen {
+		source = source[:bufflen]
+	}
+	return pre + source + rets + pos
+}
+
+// lua-5.3.4/src/ldebug.c#luaG_runerror
+// raises a runtime error, prefixed with the position of the running Lua
+// function (if any)
+func (self *luaState) runError(format string, a ...interface{}) int {
+	msg := self.addInfo(fmt.Sprintf(format, a...))
+	self.stack.check(1)
+	self.stack.push(msg)
+	return self.Error()
+}
+
+// lua-5.3.4/src/ldebug.c#luaG_addinfo
+func (self *luaState) addInfo(msg string) string {
+	if frame := self.stack; frame.isLua() { // if Lua function, add source:line information
+		return fmt.Sprintf("%s:%d: %s", frame.shortSrc(), frame.currentLine(), msg)
+	}
+	return msg
+}
+
+// lua-5.3.4/src/ldebug.c#luaG_typeerror
+// operand tells which operand of the running instruction val was read from
+func (self *luaState) runTypeError(val luaValue, operand int, op string) int {
+	t := self.objTypeName(val)
+	return self.runError("attempt to %s a %s value%s", op, t, self.varInfo(val, operand))
+}
+
+// lua-5.3.4/src/ldebug.c#luaG_opinterror
+func (self *luaState) opIntError(a, b luaValue, msg string) int {
+	if _, ok := convertToFloat(a); !ok { // first operand is wrong?
+		return self.runTypeError(a, 0, msg)
+	}
+	return self.runTypeError(b, 1, msg)
+}
+
+// lua-5.3.4/src/ldebug.c#luaG_tointerror
+func (self *luaState) toIntError(a, b luaValue) int {
+	val, operand := b, 1
+	if _, ok := convertToInteger(a); !ok {
+		val, operand = a, 0
+	}
+	return self.runError("number has no integer representation%s",
+		self.varInfo(val, operand))
+}
+
+// lua-5.3.4/src/ldebug.c#luaG_concaterror
+func (self *luaState) concatError(a, b luaValue, operand int) int {
+	switch a.(type) {
+	case string, int64, float64:
+		return self.runTypeError(b, operand+1, "concatenate")
+	}
+	return self.runTypeError(a, operand, "concatenate")
+}
+
+// lua-5.3.4/src/ldebug.c#luaG_ordererror
+func (self *luaState) orderError(a, b luaValue) int {
+	t1 := self.objTypeName(a)
+	t2 := self.objTypeName(b)
+	if t1 == t2 {
+		return self.runError("attempt to compare two %s values", t1)
+	}
+	return self.runError("attempt to compare %s with %s", t1, t2)
+}
+
+// lua-5.3.4/src/ltm.c#luaT_objtypename
+func (self *luaState) objTypeName(val luaValue) string {
+	switch val.(type) {
+	case *luaTable, *userdata:
+		if name, ok := getMetaField(val, "__name", self).(string); ok {
+			return name // use it as type name
+		}
+	}
+	return self.TypeName(typeOf(val))
+}
+
+// lua-5.3.4/src/ldebug.c#varinfo
+// describes where the running instruction read its operand-th operand from,
+// e.g. " (local 'x')", provided that the operand still holds val
+func (self *luaState) varInfo(val luaValue, operand int) string {
+	frame := self.stack
+	if !frame.isLua() {
+		return ""
+	}
+	proto := frame.closure.proto
+	pc := frame.pc - 1
+	if pc < 0 || pc >= len(proto.Code) {
+		return ""
+	}
+	i := vm.Instruction(proto.Code[pc])
+	a, b, c := i.ABC()
+	reg := -1
+	switch i.OpCode() {
+	case vm.OP_GETTABUP:
+		return upvalInfo(frame, b, val)
+	case vm.OP_SETTABUP:
+		return upvalInfo(frame, a, val)
+	case vm.OP_GETTABLE, vm.OP_SELF, vm.OP_UNM, vm.OP_BNOT, vm.OP_LEN:
+		reg = b
+	case vm.OP_SETTABLE, vm.OP_CALL, vm.OP_TAILCALL, vm.OP_TFORCALL:
+		reg = a
+	case vm.OP_CONCAT:
+		reg = b + operand
+	case vm.OP_ADD, vm.OP_SUB, vm.OP_MUL, vm.OP_MOD, vm.OP_POW, vm.OP_DIV,
+		vm.OP_IDIV, vm.OP_BAND, vm.OP_BOR, vm.OP_BXOR, vm.OP_SHL, vm.OP_SHR:
+		if operand == 0 {
+			reg = b
+		} else {
+			reg = c
+		}
+		if reg > 0xFF { // a constant is not a variable
+			return ""
+		}
+	}
+	if reg < 0 || reg >= frame.top || frame.slots[reg] != val {
+		return ""
+	}
+	if kind, name := getObjName(proto, pc, reg); kind != "" {
+		return fmt.Sprintf(" (%s '%s')", kind, name)
+	}
+	return ""
+}
+
+func upvalInfo(frame *luaStack, idx int, val luaValue) string {
+	upvals := frame.closure.upvals
+	if idx >= len(upvals) || *upvals[idx].val != val {
+		return ""
+	}
+	return fmt.Sprintf(" (upvalue '%s')", upvalName(frame.closure.proto, idx))
+}
+
+// lua-5.3.4/src/ldebug.c#upvalname
+func upvalName(proto *binchunk.Prototype, uv int) string {
+	if uv < len(proto.UpvalueNames) && proto.UpvalueNames[uv] != "" {
+		return proto.UpvalueNames[uv]
+	}
+	return "?"
+}
+
+// lua-5.3.4/src/lfunc.c#luaF_getlocalname
+// looks for the n-th local variable (1-based) active at instruction pc
+func getLocalName(proto *binchunk.Prototype, n, pc int) string {
+	for _, locVar := range proto.LocVars {
+		if int(locVar.StartPC) > pc {
+			break
+		}
+		if pc < int(locVar.EndPC) { // is variable active?
+			n--
+			if n == 0 {
+				return locVar.VarName
+			}
+		}
+	}
+	return "" // not found
+}
+
+// lua-5.3.4/src/ldebug.c#filterpc
+func filterPC(pc, jmpTarget int) int {
+	if pc < jmpTarget { // is code conditional (inside a jump)?
+		return -1 // cannot know who sets that register
+	}
+	return pc // current position sets that register
+}
+
+// lua-5.3.4/src/ldebug.c#findsetreg
+// tries to find last instruction before lastPC that modified register reg
+func findSetReg(proto *binchunk.Prototype, lastPC, reg int) int {
+	setReg := -1   // keep last instruction that changed 'reg'
+	jmpTarget := 0 // any code before this address is conditional
+	for pc := 0; pc < lastPC; pc++ {
+		i := vm.Instruction(proto.Code[pc])
+		a, b, _ := i.ABC()
+		switch i.OpCode() {
+		case vm.OP_LOADNIL:
+			if a <= reg && reg <= a+b { // set registers from 'a' to 'a+b'
+				setReg = filterPC(pc, jmpTarget)
+			}
+		case vm.OP_TFORCALL:
+			if reg >= a+2 { // affect all regs above its base
+				setReg = filterPC(pc, jmpTarget)
+			}
+		case vm.OP_CALL, vm.OP_TAILCALL:
+			if reg >= a { // affect all registers above base
+				setReg = filterPC(pc, jmpTarget)
+			}
+		case vm.OP_JMP:
+			_, sBx := i.AsBx()
+			dest := pc + 1 + sBx
+			// jump is forward and do not skip 'lastpc'?
+			if pc < dest && dest <= lastPC && dest > jmpTarget {
+				jmpTarget = dest // update 'jmptarget'
+			}
+		default:
+			if i.SetsA() && reg == a { // any instruction that set A
+				setReg = filterPC(pc, jmpTarget)
+			}
+		}
+	}
+	return setReg
+}
+
+// lua-5.3.4/src/ldebug.c#getobjname
+func getObjName(proto *binchunk.Prototype, lastPC, reg int) (kind, name string) {
+	if name = getLocalName(proto, reg+1, lastPC); name != "" { // is a local?
+		return "local", name
+	}
+	// else try symbolic execution
+	pc := findSetReg(proto, lastPC, reg)
+	if pc == -1 { // could not find instruction?
+		return "", ""
+	}
+	i := vm.Instruction(proto.Code[pc])
+	a, b, c := i.ABC()
+	switch op := i.OpCode(); op {
+	case vm.OP_MOVE:
+		if b < a { // move from 'b' to 'a'
+			return getObjName(proto, pc, b) // get name for 'b'
+		}
+	case vm.OP_GETTABUP, vm.OP_GETTABLE:
+		var vn string // name of indexed variable
+		if op == vm.OP_GETTABLE {
+			vn = getLocalName(proto, b+1, pc)
+		} else {
+			vn = upvalName(proto, b)
+		}
+		name = kName(proto, pc, c)
+		if vn == "_ENV" {
+			return "global", name
+		}
+		return "field", name
+	case vm.OP_GETUPVAL:
+		return "upvalue", upvalName(proto, b)
+	case vm.OP_LOADK, vm.OP_LOADKX:
+		_, bx := i.ABx()
+		if op == vm.OP_LOADKX {
+			bx = vm.Instruction(proto.Code[pc+1]).Ax()
+		}
+		if s, ok := proto.Constants[bx].(string); ok {
+			return "constant", s
+		}
+	case vm.OP_SELF:
+		return "method", kName(proto, pc, c)
+	}
+	return "", "" // could not find reasonable name
+}
+
+// lua-5.3.4/src/ldebug.c#kname
+func kName(proto *binchunk.Prototype, pc, c int) string {
+	if c > 0xFF { // is 'c' a constant?
+		if s, ok := proto.Constants[c&0xFF].(string); ok { // literal constant?
+			return s // it is its own name
+		}
+	} else { // 'c' is a register
+		if kind, name := getObjName(proto, pc, c); kind == "constant" {
+			return name // found a constant name
+		}
+	}
+	return "?" // no reasonable name found
+}
+
+// lua-5.3.4/src/ldebug.c#getfuncname
+// tries to find a name for the function running in this frame by looking at
+// the instruction that called it
+func (self *luaStack) funcName() (kind, name string) {
+	if prev := self.prev; !self.isTailCall && prev != nil && prev.isLua() {
+		return funcNameFromCode(prev)
+	}
+	return "", "" // no way to determine the name
+}
+
+// lua-5.3.4/src/ldebug.c#funcnamefromcode
+func funcNameFromCode(frame *luaStack) (kind, name string) {
+	if frame.isHooked { // was it called inside a hook?
+		return "hook", "?"
+	}
+	proto := frame.closure.proto
+	pc := frame.pc - 1
+	if pc < 0 || pc >= len(proto.Code) {
+		return "", ""
+	}
+	i := vm.Instruction(proto.Code[pc])
+	a, _, _ := i.ABC()
+	switch op := i.OpCode(); op {
+	case vm.OP_CALL, vm.OP_TAILCALL:
+		return getObjName(proto, pc, a) // get function name
+	case vm.OP_TFORCALL: // for iterator
+		return "for iterator", "for iterator"
+	// other instructions can do calls through metamethods
+	case vm.OP_SELF, vm.OP_GETTABUP, vm.OP_GETTABLE:
+		name = "index"
+	case vm.OP_SETTABUP, vm.OP_SETTABLE:
+		name = "newindex"
+	case vm.OP_ADD, vm.OP_SUB, vm.OP_MUL, vm.OP_MOD, vm.OP_POW, vm.OP_DIV,
+		vm.OP_IDIV, vm.OP_BAND, vm.OP_BOR, vm.OP_BXOR, vm.OP_SHL, vm.OP_SHR:
+		name = operators[op-vm.OP_ADD].metamethod[2:]
+	case vm.OP_UNM:
+		name = "unm"
+	case vm.OP_BNOT:
+		name = "bnot"
+	case vm.OP_LEN:
+		name = "len"
+	case vm.OP_CONCAT:
+		name = "concat"
+	case vm.OP_EQ:
+		name = "eq"
+	case vm.OP_LT:
+		name = "lt"
+	case vm.OP_LE:
+		name = "le"
+	default:
+		return "", ""
+	}
+	return "metamethod", name
+}
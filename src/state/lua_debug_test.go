@@ -0,0 +1,68 @@
+package state
+
+import (
+	"api"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+var hookEvents = []string{"call", "return", "line", "count", "tail call"}
+
+// runs chunk with a hook recording its events: "call <linedefined>",
+// "return <linedefined>", "line <line>" and "count"
+func traceHooks(t *testing.T, chunk string, mask, count int) string {
+	t.Helper()
+	ls := New()
+	ls.OpenLibs()
+	if err := ls.LoadStringE(chunk); err != nil {
+		t.Fatal(err)
+	}
+	var events []string
+	ls.SetHook(func(ls api.LuaState, ar *api.Debug) {
+		ls.GetInfo("S", ar)
+		switch ar.Event {
+		case api.LUA_HOOKLINE:
+			events = append(events, fmt.Sprintf("line %d", ar.CurrentLine))
+		case api.LUA_HOOKCOUNT:
+			events = append(events, "count")
+		default:
+			events = append(events, fmt.Sprintf("%s %s%d", hookEvents[ar.Event], ar.What, ar.LineDefined))
+		}
+	}, mask, count)
+	if err := ls.CallE(0, 0); err != nil {
+		t.Fatal(err)
+	}
+	return strings.Join(events, ", ")
+}
+
+const hookedChunk = `local function f(x)
+  return x + 1
+end
+local y = f(1)
+y = y + f(2)`
+
+func TestHookEvents(t *testing.T) {
+	cases := []struct {
+		chunk       string
+		mask, count int
+		want        string
+	}{
+		{hookedChunk, api.LUA_MASKCALL | api.LUA_MASKRET | api.LUA_MASKLINE, 0,
+			"call main0, line 3, line 4, call Lua1, line 2, return Lua1, " +
+				"line 5, call Lua1, line 2, return Lua1, return main0"},
+		{hookedChunk, api.LUA_MASKCALL | api.LUA_MASKRET, 0,
+			"call main0, call Lua1, return Lua1, call Lua1, return Lua1, return main0"},
+		{hookedChunk, api.LUA_MASKLINE, 0,
+			"line 3, line 4, line 2, line 5, line 2"},
+		{hookedChunk, api.LUA_MASKCOUNT, 3, "count, count, count, count"},
+		// Go functions are hooked too, and a tail call replaces its caller
+		{"local function f() return type(1) end\nreturn f()", api.LUA_MASKCALL | api.LUA_MASKRET, 0,
+			"call main0, tail call Lua1, call C-1, return C-1, return Lua1"},
+	}
+	for _, c := range cases {
+		if got := traceHooks(t, c.chunk, c.mask, c.count); got != c.want {
+			t.Errorf("mask %d, count %d:\ngot  %s\nwant %s", c.mask, c.count, got, c.want)
+		}
+	}
+}
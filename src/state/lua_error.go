@@ -0,0 +1,31 @@
+package state
+
+import (
+	"api"
+	"fmt"
+)
+
+// panic payload used to unwind the Go stack when a Lua error is raised;
+// recovered by PCall
+type luaError struct {
+	status int      // LUA_ERRRUN, LUA_ERRERR...
+	value  luaValue // the error object
+}
+
+// so that errors escaping an unprotected call still read well
+func (self *luaError) Error() string {
+	if s, ok := self.value.(string); ok {
+		return s
+	}
+	return fmt.Sprintf("(error object is a %T value)", self.value)
+}
+
+// converts a recovered panic into a Lua error: panics raised by Go code
+// (runtime errors in Go functions and the like) become error messages
+func (self *luaState) toLuaError(r interface{}) *luaError {
+	if e, ok := r.(*luaError); ok {
+		return e
+	}
+	msg := fmt.Sprint(r)
+	return &luaError{status: api.LUA_ERRRUN, value: self.addInfo(msg)}
+}
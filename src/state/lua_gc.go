@@ -0,0 +1,335 @@
+package state
+
+import (
+	"api"
+	"unsafe"
+	"vm"
+)
+
+// defaults reported by setpause/setstepmul, as in Lua
+const (
+	LUAI_GCPAUSE = 200 // 200%
+	LUAI_GCMUL   = 200 // GC runs 'twice the speed' of memory allocation
+)
+
+// allocations between two estimates made for the memory limit, as a
+// fraction of the limit, see account
+const MEMDEBT_DIV = 16
+
+// lua-5.3.4/src/lgc.c#luaC_checkfinalizer
+// marks a table or userdata whose new metatable has a __gc field, so that
+// the metamethod runs once the object becomes unreachable. As in Lua, a
+// __gc field added to the metatable later has no effect
+func (self *luaState) checkFinalizer(val luaValue, mt *luaTable) {
+	if mt == nil || mt.get("__gc") == nil {
+		return
+	}
+	obj := gcObjectOf(val)
+	if obj == nil || obj.marked {
+		return
+	}
+	obj.marked = true
+	// the list keeps the object alive for Go's collector; the state finds
+	// out by itself when nothing else reaches it, see collect
+	self.global.finobj = append(self.global.finobj, val)
+}
+
+// lua-5.3.4/src/lgc.c#luaC_checkGC
+// safe point: starts a cycle once the memory total has grown enough since
+// the last one, and runs the __gc metamethods of the objects it found
+func (self *luaState) checkGC() {
+	g := self.global
+	if g.gcStopped || g.inFinalizer {
+		return
+	}
+	if g.totalBytes >= g.gcThreshold {
+		self.collect()
+	}
+	if len(g.tobefnz) > 0 {
+		self.runFinalizers()
+	}
+}
+
+// lua-5.3.4/src/lgc.c#luaC_fullgc
+// walks the objects reachable from the state, which gives a new estimate
+// of the memory in use, and moves the objects with a finalizer it did not
+// reach to the list of those to be finalized. Cycles are no different from
+// other garbage: only what the walk reaches is alive
+func (self *luaState) collect() {
+	g := self.global
+	est := self.markRoots()
+	var dead []luaValue
+	live := g.finobj[:0]
+	for _, obj := range g.finobj {
+		if est.reached(obj) {
+			live = append(live, obj)
+		} else {
+			dead = append(dead, obj)
+		}
+	}
+	clear(g.finobj[len(live):]) // do not keep the separated objects
+	for co := range g.coroutines {
+		if !est.reached(co) && co.coStatus == api.LUA_YIELD {
+			co.closeThread(self) // nothing can resume it
+		}
+	}
+	g.finobj = live
+	for i := len(dead) - 1; i >= 0; i-- { // newest are finalized first
+		g.tobefnz = append(g.tobefnz, dead[i])
+	}
+	// objects being finalized, and what they reach, are alive again
+	for _, obj := range g.tobefnz {
+		est.visit(obj)
+	}
+	g.totalBytes = int64(est.size)
+	g.estimate = g.totalBytes
+	g.gcThreshold = g.totalBytes / 100 * int64(g.gcPause)
+}
+
+// lua-5.3.4/src/lgc.c#GCTM
+func (self *luaState) runFinalizers() {
+	g := self.global
+	g.inFinalizer = true // finalizers do not run other finalizers
+	defer func() { g.inFinalizer = false }()
+	for len(g.tobefnz) > 0 {
+		obj := g.tobefnz[0]
+		g.tobefnz[0] = nil
+		g.tobefnz = g.tobefnz[1:]
+		gcObjectOf(obj).marked = false // setmetatable may mark it again
+		tm := getMetaField(obj, "__gc", self)
+		if _, ok := tm.(*closure); !ok { // __gc removed meanwhile?
+			continue
+		}
+		self.stack.check(2)
+		self.stack.push(tm)
+		self.stack.push(obj)
+		if self.PCall(1, 0, 0) != 0 {
+			// errors in finalizers are not propagated to unrelated code
+			self.stack.pop()
+		}
+	}
+}
+
+// lua-5.3.4/src/lapi.c#lua_gc (LUA_GCCOUNT)
+// estimate, in bytes, of the memory held by the objects reachable from
+// the state
+func (self *luaState) heapSize() int {
+	est := self.markRoots()
+	for _, obj := range self.global.tobefnz {
+		est.visit(obj)
+	}
+	return est.size
+}
+
+// lua-5.3.4/src/lgc.c#restartcollection
+// walks the objects reachable from the registry and the running thread
+func (self *luaState) markRoots() *heapEstimator {
+	est := &heapEstimator{seen: map[unsafe.Pointer]bool{}}
+	est.visit(self.registry)
+	est.visit(self)
+	return est
+}
+
+// rough sizes of the Go representations of Lua values
+const (
+	sizeValue   = int(unsafe.Sizeof(luaValue(nil)))
+	sizeTable   = int(unsafe.Sizeof(luaTable{}))
+	sizeNode    = int(unsafe.Sizeof(tableNode{})) + 2*sizeValue // node + map entry
+	sizeClosure = int(unsafe.Sizeof(closure{}))
+	sizeUpvalue = int(unsafe.Sizeof(upvalue{})) + sizeValue
+	sizeUdata   = int(unsafe.Sizeof(userdata{}))
+	sizeState   = int(unsafe.Sizeof(luaState{}))
+	sizeStack   = int(unsafe.Sizeof(luaStack{}))
+)
+
+// bytes held by the array and hash parts of the table
+func (self *luaTable) footprint() int {
+	return cap(self.arr)*sizeValue + cap(self.nodes)*sizeNode
+}
+
+// lua-5.3.4/src/lmem.c#luaM_realloc_
+// accounts for n bytes about to be allocated
+func (self *luaState) allocate(n int) {
+	self.account(n, n)
+}
+
+// accounts for n bytes just allocated by a growing object
+func (self *luaState) grow(n int) {
+	self.account(n, 0)
+}
+
+// adds n bytes to the total; once it goes over the limit, the total is
+// estimated again from the reachable objects (plus the pending bytes, not
+// allocated yet), and a memory error is raised if they do not fit either.
+// The walk costs as much as there are live objects, so it is not made
+// again before 1/MEMDEBT_DIV of the limit was allocated since the last one
+// (or a memory error unwound the stack, leaving garbage behind): until then,
+// going over the limit is an error
+func (self *luaState) account(n, pending int) {
+	g := self.global
+	g.totalBytes += int64(n)
+	if g.memLimit > 0 && g.totalBytes > g.memLimit {
+		if g.memError || g.totalBytes-g.estimate >= g.memLimit/MEMDEBT_DIV {
+			g.estimate = int64(self.heapSize())
+			g.totalBytes = g.estimate + int64(pending)
+			g.memError = false
+		}
+		if g.totalBytes > g.memLimit {
+			g.totalBytes -= int64(pending) // not allocated after all
+			g.memError = true
+			panic(&luaError{status: api.LUA_ERRMEM, value: "not enough memory"})
+		}
+	}
+}
+
+// like allocate, but reports a memory error instead of raising it
+func (self *luaState) tryAllocate(n int) (ok bool) {
+	defer func() {
+		if r := recover(); r != nil {
+			if e, isErr := r.(*luaError); !isErr || e.status != api.LUA_ERRMEM {
+				panic(r)
+			}
+			ok = false
+		}
+	}()
+	self.allocate(n)
+	return true
+}
+
+type heapEstimator struct {
+	seen map[unsafe.Pointer]bool
+	size int
+}
+
+// first time an object is reached?
+func (self *heapEstimator) mark(p unsafe.Pointer) bool {
+	if self.seen[p] {
+		return false
+	}
+	self.seen[p] = true
+	return true
+}
+
+func (self *heapEstimator) visit(val luaValue) {
+	switch x := val.(type) {
+	case string:
+		self.size += len(x)
+	case *luaTable:
+		if !self.mark(unsafe.Pointer(x)) {
+			return
+		}
+		self.size += sizeTable + cap(x.arr)*sizeValue + cap(x.nodes)*sizeNode
+		self.visitEphemerons(&x.gcObject)
+		for k, v, _ := x.next(nil); k != nil; k, v, _ = x.next(k) {
+			// weak references do not keep objects alive; the values of
+			// ephemerons are reached through their keys
+			if x.mode&WEAK_KEYS == 0 {
+				self.visit(k)
+			}
+			if x.mode == 0 || x.mode&WEAK_VALUES == 0 && gcObjectOf(k) == nil {
+				self.visit(v)
+			}
+		}
+		if x.metatable != nil {
+			self.visit(x.metatable)
+		}
+	case *closure:
+		if !self.mark(unsafe.Pointer(x)) {
+			return
+		}
+		self.size += sizeClosure
+		self.visitEphemerons(&x.gcObject)
+		if x.proto != nil && self.mark(unsafe.Pointer(x.proto)) {
+			self.size += 4*len(x.proto.Code) + sizeValue*len(x.proto.Constants)
+		}
+		for _, uv := range x.upvals {
+			if uv != nil {
+				self.size += sizeUpvalue
+				self.visit(*uv.val)
+			}
+		}
+	case *userdata:
+		if !self.mark(unsafe.Pointer(x)) {
+			return
+		}
+		self.size += sizeUdata
+		self.visitEphemerons(&x.gcObject)
+		self.visit(x.uservalue)
+		if x.metatable != nil {
+			self.visit(x.metatable)
+		}
+	case *luaState:
+		if !self.mark(unsafe.Pointer(x)) {
+			return
+		}
+		self.size += sizeState
+		self.visitEphemerons(&x.gcObject)
+		if x.coCaller != nil { // resumed x, waits for it
+			self.visit(x.coCaller)
+		}
+		for frame := x.stack; frame != nil; frame = frame.prev {
+			self.visitFrame(frame)
+		}
+	}
+}
+
+func (self *heapEstimator) visitFrame(frame *luaStack) {
+	self.size += sizeStack + len(frame.slots)*sizeValue
+	slots := frame.slots[:frame.top]
+	if frame.isLua() {
+		code := frame.closure.proto.Code
+		nRegs := int(frame.closure.proto.MaxStackSize)
+		if pc := frame.pc - 1; pc >= 0 && pc < len(code) && nRegs <= len(slots) {
+			// while a Lua function calls another, its registers from the
+			// called function up only hold stale copies of what the callee
+			// received (in Lua, they lie above the top)
+			i := vm.Instruction(code[pc])
+			a, _, _ := i.ABC()
+			live := nRegs
+			switch i.OpCode() {
+			case vm.OP_CALL:
+				live = a
+			case vm.OP_TFORCALL:
+				live = a + 3
+			}
+			for _, v := range slots[:live] {
+				self.visit(v)
+			}
+			slots = slots[nRegs:] // function and arguments being pushed
+		}
+	}
+	for _, v := range slots {
+		self.visit(v)
+	}
+	for _, v := range frame.varargs {
+		self.visit(v)
+	}
+	if frame.closure != nil {
+		self.visit(frame.closure)
+	}
+}
+
+// values that weak-keyed tables still alive store for this key; those of
+// the collected tables are dropped
+func (self *heapEstimator) visitEphemerons(obj *gcObject) {
+	for wt, v := range obj.ephemerons {
+		if wt.Value() != nil {
+			self.visit(v)
+		} else {
+			delete(obj.ephemerons, wt)
+		}
+	}
+}
+
+// was the table, userdata or thread reached by the walk?
+func (self *heapEstimator) reached(val luaValue) bool {
+	switch x := val.(type) {
+	case *luaTable:
+		return self.seen[unsafe.Pointer(x)]
+	case *userdata:
+		return self.seen[unsafe.Pointer(x)]
+	case *luaState:
+		return self.seen[unsafe.Pointer(x)]
+	}
+	return true
+}
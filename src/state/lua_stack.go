@@ -12,6 +12,9 @@ type luaStack struct {
 	varargs []luaValue
 	state   *luaState
 	openuvs map[int]*upvalue
+	// the function was called by a tail call, its caller's frame is gone
+	isTailCall bool
+	isHooked   bool // running a hook
 }
 
 func newLuaStack(size int, state *luaState) *luaStack {
@@ -135,8 +138,9 @@ func (self *luaStack) pushN(vals []luaValue, n int) {
 }
 
 func (self *luaStack) check(n int) {
-	free := len(self.slots)
+	free := len(self.slots) - self.top
 	for i := free; i < n; i++ {
 		self.slots = append(self.slots, nil)
+		self.state.nSlots++
 	}
 }
@@ -1,16 +1,108 @@
 package state
 
-import "api"
+import (
+	"api"
+	"context"
+	"math/rand"
+	"stdlib"
+	"time"
+	"vm"
+)
+
+// default limits of a thread's stack
+const (
+	LUAI_MAXCALLS = 200000            // nested calls
+	LUAI_MAXSLOTS = api.LUAI_MAXSTACK // slots of all frames
+)
+
+// room left to message handlers once the stack overflowed
+const (
+	ERRORSTACK_CALLS = 50
+	ERRORSTACK_SLOTS = 5000
+)
+
+// limit for table tag-method chains (to avoid loops)
+const MAXTAGLOOP = 2000
+
+// number of instructions run between two checks of the context
+const CONTEXT_CHECK_INTERVAL = 1000
+
+// data shared by all the threads of a state
+type globalState struct {
+	maxCalls int
+	maxSlots int
+	/* host facilities */
+	deterministic bool
+	rand          *rand.Rand // source of math.random
+	startTime     time.Time  // origin of Clock
+	clock         float64    // virtual clock, in deterministic mode
+	lastID        uint64     // last object id given out by objectID
+	/* interruptions */
+	ctx            context.Context
+	ctxDone        <-chan struct{} // ctx.Done(), nil if ctx cannot be canceled
+	ctxCountdown   int             // instructions left before the next check
+	metering       bool
+	budget         int64                     // what is left to spend
+	opWeights      [vm.OP_EXTRAARG + 1]int64 // cost of each opcode
+	interruptCause error                     // cause of the last interruption raised by the running CallE
+	/* memory accounting */
+	totalBytes int64 // live bytes at the last estimate, plus those allocated since
+	estimate   int64 // live bytes found by the last walk of the heap
+	memLimit   int64 // 0 for none
+	memError   bool  // a memory error was raised since the last walk
+	/* garbage collection */
+	coroutines  map[*luaState]bool // threads with a goroutine, see Resume
+	finobj      []luaValue         // objects with a finalizer, see checkFinalizer
+	tobefnz     []luaValue         // unreachable objects whose __gc is yet to run
+	gcThreshold int64              // totalBytes starting the next automatic cycle
+	inFinalizer bool
+	gcStopped   bool
+	gcPause     int
+	gcStepMul   int
+}
 
 type luaState struct {
 	registry *luaTable
 	stack    *luaStack
+	global   *globalState
+	gcObject
+	/* stack limits */
+	nCalls         int  // number of frames
+	nSlots         int  // slots allocated by all frames
+	inErrorHandler bool // running a message handler
+	/* hooks */
+	hook          api.Hook
+	hookMask      int
+	baseHookCount int
+	hookCount     int
+	inHook        bool // running a hook, which cannot be hooked
+	oldPC         int  // last pc traced by the line hook
+	/* coroutine */
+	coStatus int
+	coCaller *luaState
+	coChan   chan int
 }
 
 func New() *luaState {
+	ls := &luaState{
+		global: &globalState{
+			maxCalls:   LUAI_MAXCALLS,
+			maxSlots:   LUAI_MAXSLOTS,
+			rand:       rand.New(rand.NewSource(time.Now().UnixNano())),
+			startTime:  time.Now(),
+			gcPause:    LUAI_GCPAUSE,
+			coroutines: map[*luaState]bool{},
+			gcStepMul:  LUAI_GCMUL,
+		},
+	}
+	for op := range ls.global.opWeights {
+		ls.global.opWeights[op] = 1
+	}
 	registry := newLuaTable(0, 0)
+	registry.set(api.LUA_RIDX_MAINTHREAD, ls)
 	registry.set(api.LUA_RIDX_GLOBALS, newLuaTable(0, 0))
-	ls := &luaState{registry: registry}
+	registry.set(stdlib.HOSTKEY, newUserdata(ls.global))
+	ls.registry = registry
 	ls.pushLuaStack(newLuaStack(api.LUA_MINSATCK, ls))
 	return ls
 }
@@ -19,11 +111,28 @@ func (self *luaState) popLuaStack() {
 	stack := self.stack
 	self.stack = stack.prev
 	stack.prev = nil
+	self.nCalls--
+	self.nSlots -= len(stack.slots)
 }
 
 func (self *luaState) pushLuaStack(stack *luaStack) {
 	stack.prev = self.stack
 	self.stack = stack
+	self.nCalls++
+	self.nSlots += len(stack.slots)
+}
+
+// raises a "stack overflow" error if nCalls more frames and nSlots more
+// slots would exceed the limits of the thread
+func (self *luaState) checkStackLimits(nCalls, nSlots int) {
+	maxCalls, maxSlots := self.global.maxCalls, self.global.maxSlots
+	if self.inErrorHandler { // let the handler report the overflow
+		maxCalls += ERRORSTACK_CALLS
+		maxSlots += ERRORSTACK_SLOTS
+	}
+	if self.nCalls+nCalls > maxCalls || self.nSlots+nSlots > maxSlots {
+		self.runError("stack overflow")
+	}
 }
 
 //func New(stackSize int, proto *binchunk.Prototype) *luaState {
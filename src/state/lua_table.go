@@ -8,10 +8,18 @@ import (
 type luaTable struct {
 	metatable *luaTable
 	arr       []luaValue
-	_map      map[luaValue]luaValue
-	keys      map[luaValue]luaValue // used by next()
-	lastKey   luaValue              // used by next()
-	changed   bool                  // used by next()
+	_map      map[luaValue]int // key -> index in nodes
+	nodes     []tableNode      // hash part, in insertion order
+	nDead     int              // nodes whose value is nil
+	mode      int              // WEAK_KEYS, WEAK_VALUES
+	gcObject
+}
+
+// entry of the hash part; nodes whose value was set to nil stay in place
+// (and in _map) until the next insertion, so that traversals can go on
+type tableNode struct {
+	key luaValue
+	val luaValue
 }
 
 func newLuaTable(nArr, nRec int) *luaTable {
@@ -20,7 +28,8 @@ func newLuaTable(nArr, nRec int) *luaTable {
 		t.arr = make([]luaValue, 0, nArr)
 	}
 	if nRec > 0 {
-		t._map = make(map[luaValue]luaValue, nRec)
+		t._map = make(map[luaValue]int, nRec)
+		t.nodes = make([]tableNode, 0, nRec)
 	}
 	return t
 }
@@ -30,18 +39,88 @@ func (self *luaTable) hasMetafield(fieldName string) bool {
 		self.metatable.get(fieldName) != nil
 }
 
+// lua-5.3.4/src/ltable.c#luaH_getn
+// returns a border: an index j such that t[j] is not nil and t[j+1] is nil
+// (0 if t[1] is nil)
 func (self *luaTable) len() int {
-	return len(self.arr)
+	j := len(self.arr)
+	if j > 0 && self._arrGet(j-1) == nil {
+		// there is a border in the array part: binary search for it
+		i := 0
+		for j-i > 1 {
+			m := (i + j) / 2
+			if self._arrGet(m-1) == nil {
+				j = m
+			} else {
+				i = m
+			}
+		}
+		return i
+	}
+	if len(self._map) == 0 {
+		return j
+	}
+	return self._unboundSearch(j)
+}
+
+// lua-5.3.4/src/ltable.c#unbound_search
+// looks for a border in the hash part, t[j] is not nil (or j is 0)
+func (self *luaTable) _unboundSearch(j int) int {
+	i := j // i is zero or a present index
+	j++
+	// find 'i' and 'j' such that i is present and j is not
+	for self.get(int64(j)) != nil {
+		i = j
+		if j > math.MaxInt32/2 { // overflow?
+			// table was built with bad purposes: resort to linear search
+			i = 1
+			for self.get(int64(i)) != nil {
+				i++
+			}
+			return i - 1
+		}
+		j *= 2
+	}
+	// now do a binary search between them
+	for j-i > 1 {
+		m := (i + j) / 2
+		if self.get(int64(m)) == nil {
+			j = m
+		} else {
+			i = m
+		}
+	}
+	return i
 }
 
 func (self *luaTable) get(key luaValue) luaValue {
 	key = _floatToInteger(key)
 	if idx, ok := key.(int64); ok {
 		if idx >= 1 && idx <= int64(len(self.arr)) {
-			return self.arr[idx-1]
+			return self._arrGet(int(idx - 1))
+		}
+	}
+	if self.mode == 0 {
+		if n, found := self._map[key]; found {
+			return self.nodes[n].val
 		}
+		return nil
+	}
+	mapKey := key
+	if self.mode&WEAK_KEYS != 0 { // stored as _weaken does
+		mapKey = makeWeak(key)
 	}
-	return self._map[key]
+	if n, found := self._map[mapKey]; found {
+		return self._strengthen(key, self.nodes[n].val)
+	}
+	return nil
+}
+
+func (self *luaTable) _arrGet(i int) luaValue {
+	if self.mode&WEAK_VALUES != 0 {
+		return self._strengthen(nil, self.arr[i])
+	}
+	return self.arr[i]
 }
 
 func _floatToInteger(key luaValue) luaValue {
@@ -61,8 +140,10 @@ func (self *luaTable) set(key, val luaValue) {
 		panic("table index is NaN!")
 	}
 
-	self.changed = true
 	key = _floatToInteger(key)
+	if self.mode != 0 {
+		key, val = self._weaken(key, val)
+	}
 	if idx, ok := key.(int64); ok && idx >= 1 {
 		arrLen := int64(len(self.arr))
 		if idx <= arrLen {
@@ -73,7 +154,7 @@ func (self *luaTable) set(key, val luaValue) {
 			return
 		}
 		if idx == arrLen+1 {
-			delete(self._map, key)
+			self._removeNode(key)
 			if val != nil {
 				self.arr = append(self.arr, val)
 				self._expandArray()
@@ -81,63 +162,157 @@ func (self *luaTable) set(key, val luaValue) {
 			return
 		}
 	}
+	if n, found := self._map[key]; found { // existing (or dead) key
+		node := &self.nodes[n]
+		if node.val == nil && val != nil {
+			self.nDead--
+		} else if node.val != nil && val == nil {
+			self.nDead++
+		}
+		node.val = val
+		return
+	}
 	if val != nil {
 		if self._map == nil {
-			self._map = make(map[luaValue]luaValue, 8)
+			self._map = make(map[luaValue]int, 8)
 		}
-		self._map[key] = val
-	} else {
-		delete(self._map, key)
+		if self.nDead > len(self.nodes)/2 {
+			self._compactNodes()
+		} else if self.mode != 0 && len(self.nodes) == cap(self.nodes) {
+			self._sweep()
+		}
+		self._map[key] = len(self.nodes)
+		self.nodes = append(self.nodes, tableNode{key, val})
 	}
 }
 
+// drops the trailing nils of the array part, so that its last element
+// (if any) is never nil
 func (self *luaTable) _shrinkArray() {
-	for i := len(self.arr) - 1; i >= 0; i-- {
-		if self.arr[i] == nil {
-			self.arr = self.arr[0:i]
-		}
+	i := len(self.arr)
+	for i > 0 && self.arr[i-1] == nil {
+		i--
 	}
+	self.arr = self.arr[0:i]
 }
 
+// moves the integer keys following the array part out of the hash part
 func (self *luaTable) _expandArray() {
 	for idx := int64(len(self.arr)) + 1; true; idx++ {
-		if val, found := self._map[idx]; found {
-			delete(self._map, idx)
-			self.arr = append(self.arr, val)
+		if n, found := self._map[idx]; found && self.nodes[n].val != nil {
+			self.arr = append(self.arr, self.nodes[n].val)
+			self._removeNode(idx)
 		} else {
 			break
 		}
 	}
 }
 
-func (self *luaTable) nextKey(key luaValue) luaValue {
-	if self.keys == nil || (key == nil && self.changed) {
-		self.initKeys()
-		self.changed = false
+// removes a key from the hash part, its node becomes dead
+func (self *luaTable) _removeNode(key luaValue) {
+	if n, found := self._map[key]; found {
+		if self.nodes[n].val != nil {
+			self.nodes[n].val = nil
+			self.nDead++
+		}
+		self.nodes[n].key = nil
+		delete(self._map, key)
 	}
+}
 
-	nextKey := self.keys[key]
-	if nextKey == nil && key != nil && key != self.lastKey {
-		panic("invalid key to 'next'")
+// drops the collected entries of a weak table before its hash part grows
+func (self *luaTable) _sweep() {
+	for i, node := range self.nodes {
+		if _, v := self._entry(node); node.val != nil && v == nil {
+			self.nodes[i].val = nil
+			self.nDead++
+		}
+	}
+	self._compactNodes()
+	if n := len(self.nodes); n > cap(self.nodes)/2 {
+		// mostly alive: grow now, so that sweeps stay amortized O(1)
+		self.nodes = append(make([]tableNode, 0, 2*n), self.nodes...)
 	}
+}
 
-	return nextKey
+// drops the dead nodes, keeping the order of the others
+func (self *luaTable) _compactNodes() {
+	nodes := self.nodes[:0]
+	for _, node := range self.nodes {
+		if node.val != nil {
+			self._map[node.key] = len(nodes)
+			nodes = append(nodes, node)
+		} else if node.key != nil {
+			delete(self._map, node.key)
+		}
+	}
+	for i := len(nodes); i < len(self.nodes); i++ {
+		self.nodes[i] = tableNode{} // let the GC collect them
+	}
+	self.nodes = nodes
+	self.nDead = 0
 }
 
-func (self *luaTable) initKeys() {
-	self.keys = make(map[luaValue]luaValue)
-	var key luaValue = nil
-	for i, v := range self.arr {
-		if v != nil {
-			self.keys[key] = int64(i + 1)
-			key = int64(i + 1)
+// lua-5.3.4/src/ltable.c#luaH_next
+// returns the key following the given one (nil for the first) and its
+// value, walking the array part and then the hash part in insertion
+// order; ok is false if the key cannot be found
+func (self *luaTable) next(key luaValue) (nextKey, nextVal luaValue, ok bool) {
+	i, ok := self._findIndex(key)
+	if !ok {
+		return nil, nil, false
+	}
+	for ; i < len(self.arr); i++ { // try first array part
+		if v := self._arrGet(i); v != nil {
+			return int64(i + 1), v, true
 		}
 	}
-	for k, v := range self._map {
-		if v != nil {
-			self.keys[key] = k
-			key = k
+	for i -= len(self.arr); i < len(self.nodes); i++ { // hash part
+		if node := self.nodes[i]; node.val != nil {
+			if self.mode == 0 {
+				return node.key, node.val, true
+			}
+			if k, v := self._entry(node); v != nil { // not collected?
+				return k, v, true
+			}
 		}
 	}
-	self.lastKey = key
+	return nil, nil, true // no more elements
+}
+
+// key and value of a node of a weak table, value is nil if either was
+// collected
+func (self *luaTable) _entry(node tableNode) (luaValue, luaValue) {
+	key := node.key
+	if wk, ok := key.(weakValue); ok {
+		if key = wk.value(); key == nil {
+			return nil, nil
+		}
+	}
+	return key, self._strengthen(key, node.val)
+}
+
+// lua-5.3.4/src/ltable.c#findindex
+// returns where a traversal continues after key: array indices come first,
+// followed by the indices of nodes
+func (self *luaTable) _findIndex(key luaValue) (int, bool) {
+	if key == nil { // first iteration
+		return 0, true
+	}
+	key = _floatToInteger(key)
+	if idx, ok := key.(int64); ok && idx >= 1 && idx <= int64(len(self.arr)) {
+		return int(idx), true
+	}
+	if self.mode&WEAK_KEYS != 0 {
+		key = makeWeak(key)
+	}
+	if n, found := self._map[key]; found {
+		return len(self.arr) + n + 1, true
+	}
+	// a key of the array part whose tail was removed during traversal;
+	// the array part has no more elements
+	if idx, ok := key.(int64); ok && idx >= 1 && idx <= int64(cap(self.arr)) {
+		return len(self.arr), true
+	}
+	return 0, false
 }
@@ -0,0 +1,69 @@
+package state
+
+import "testing"
+
+func TestTableLength(t *testing.T) {
+	cases := []struct {
+		name    string
+		keys    []int64 // set to true, in order
+		holes   []int64 // then set to nil
+		nArr    int     // expected size of the array part
+		borders []int   // acceptable results
+	}{
+		{"empty", nil, nil, 0, []int{0}},
+		{"array", []int64{1, 2, 3}, nil, 3, []int{3}},
+		{"array with a hole", []int64{1, 2, 3, 4, 5}, []int64{3}, 5, []int{2, 5}},
+		{"array with holes", []int64{1, 2, 3, 4, 5, 6, 7, 8}, []int64{2, 4, 6}, 8, []int{1, 3, 5, 8}},
+		{"hash part only", []int64{3, 2}, nil, 0, []int{0}},
+		{"hash part without 1", []int64{2, 3, 4}, nil, 0, []int{0}},
+		{"border at the boundary", []int64{1, 2, 3, 5, 6}, nil, 3, []int{3}},
+		{"border past the boundary", []int64{1, 2, 3, 6, 5, 4}, nil, 6, []int{6}},
+		{"border in the hash part", []int64{1, 2, 4, 5, 6}, []int64{}, 2, []int{2}},
+	}
+	for _, c := range cases {
+		tbl := newLuaTable(0, 0)
+		for _, k := range c.keys {
+			tbl.set(k, true)
+		}
+		for _, k := range c.holes {
+			tbl.set(k, nil)
+		}
+		if len(tbl.arr) != c.nArr {
+			t.Errorf("%s: array part of %d, want %d", c.name, len(tbl.arr), c.nArr)
+		}
+		n := tbl.len()
+		if (n > 0 && tbl.get(int64(n)) == nil) || tbl.get(int64(n+1)) != nil {
+			t.Errorf("%s: %d is not a border", c.name, n)
+			continue
+		}
+		ok := false
+		for _, b := range c.borders {
+			ok = ok || n == b
+		}
+		if !ok {
+			t.Errorf("%s: got %d, want one of %v", c.name, n, c.borders)
+		}
+	}
+}
+
+func TestClearFieldsDuringTraversal(t *testing.T) {
+	ls := New()
+	ls.OpenLibs()
+	err := ls.DoStringE(`
+		local t = {10, 20, 30, 40}
+		for i = 1, 100 do t["k" .. i] = i end
+		t[1.5], t[true] = 1, 2
+		local seen = 0
+		for k in pairs(t) do
+			t[k] = nil -- clearing the current field is allowed
+			seen = seen + 1
+		end
+		assert(seen == 106, seen)
+		assert(next(t) == nil)
+		-- the table is still usable afterwards
+		t.x = 1
+		assert(next(t) == "x")`)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,18 @@
+package state
+
+import "unsafe"
+
+// light userdata: a bare pointer, compared by identity
+type lightUserdata unsafe.Pointer
+
+// full userdata: a Go value with its own metatable and user value
+type userdata struct {
+	metatable *luaTable
+	uservalue luaValue
+	data      interface{}
+	gcObject
+}
+
+func newUserdata(data interface{}) *userdata {
+	return &userdata{data: data}
+}
@@ -9,17 +9,31 @@ import (
 type luaValue interface{}
 
 func setMetatable(val luaValue, mt *luaTable, ls *luaState) {
-	if t, ok := val.(*luaTable); ok {
-		t.metatable = mt
+	switch x := val.(type) {
+	case *luaTable:
+		x.metatable = mt
+		x.setWeakMode(weakMode(mt))
+		ls.checkFinalizer(x, mt)
+		return
+	case *userdata:
+		x.metatable = mt
+		ls.checkFinalizer(x, mt)
 		return
 	}
 	key := fmt.Sprintf("_MT%d", typeOf(val))
-	ls.registry.set(key, val)
+	if mt == nil { // a nil *luaTable is not a nil luaValue
+		ls.registry.set(key, nil)
+	} else {
+		ls.registry.set(key, mt)
+	}
 }
 
 func getMetatable(val luaValue, ls *luaState) *luaTable {
-	if t, ok := val.(*luaTable); ok {
-		return t.metatable
+	switch x := val.(type) {
+	case *luaTable:
+		return x.metatable
+	case *userdata:
+		return x.metatable
 	}
 	key := fmt.Sprintf("_MT%d", typeOf(val))
 	if mt := ls.registry.get(key); mt != nil {
@@ -44,6 +58,10 @@ func typeOf(val luaValue) api.LuaType {
 		return api.LUA_TFUNCTION
 	case *luaState:
 		return api.LUA_TTHREAD
+	case *userdata:
+		return api.LUA_TUSERDATA
+	case lightUserdata:
+		return api.LUA_TLIGHTUSERDATA
 	default:
 		panic("unknown type!")
 	}
@@ -55,7 +73,10 @@ func convertToFloat(val luaValue) (float64, bool) {
 		return x, true
 	case int64:
 		return float64(x), true
-	case string:
+	case string: // lua-5.3.4/src/lvm.c#luaV_tonumber_
+		if i, ok := number.ParseInteger(x); ok {
+			return float64(i), true
+		}
 		return number.ParseFloat(x)
 	default:
 		return 0, false
@@ -85,10 +106,12 @@ func stringToInteger(str string) (int64, bool) {
 	return 0, false
 }
 
+// lua-5.3.4/src/ltm.c#luaT_trybinTM
+// calls the metamethod of the first operand, or else of the second one
 func callMetamethod(a, b luaValue, mmName string, ls *luaState) (luaValue, bool) {
 	var mm luaValue
 	if mm = getMetaField(a, mmName, ls); mm == nil {
-		if mm = getMetaField(a, mmName, ls); mm == nil {
+		if mm = getMetaField(b, mmName, ls); mm == nil {
 			return nil, false
 		}
 	}
@@ -0,0 +1,79 @@
+package state
+
+import (
+	"api"
+	"testing"
+)
+
+// runs the conformance checks of bin/metamethods.lua, which raise an error
+// on the first failure
+func TestMetamethodConformance(t *testing.T) {
+	ls := New()
+	ls.OpenLibs()
+	ls.Register("print", func(ls api.LuaState) int { return 0 }) // quiet
+	if err := ls.DoFileE("../../bin/metamethods.lua"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMetamethodsOfGoValues(t *testing.T) {
+	ls := New()
+	ls.OpenLibs()
+	// newudata(mt) returns a full userdata with metatable mt
+	ls.Register("newudata", func(ls api.LuaState) int {
+		ls.NewUserData(nil)
+		ls.PushValue(1)
+		ls.SetMetatable(-2)
+		return 1
+	})
+	// the __call of adder(n) is a Go closure adding n to its argument
+	ls.Register("adder", func(ls api.LuaState) int {
+		ls.NewTable()
+		ls.NewTable()
+		ls.PushValue(1)
+		ls.PushGoClosure(func(ls api.LuaState) int {
+			ls.PushInteger(ls.ToInteger(ls.UpvalueIndex(1)) + ls.CheckInteger(2))
+			return 1
+		}, 1)
+		ls.SetField(-2, "__call")
+		ls.SetMetatable(-2)
+		return 1
+	})
+	err := ls.DoStringE(`
+		local u = newudata({__len = function(u) return 7 end})
+		assert(#u == 7)
+		local ok, msg = pcall(function() return #newudata({}) end)
+		assert(not ok and msg:find("attempt to get length of a userdata value"), msg)
+		local add2 = adder(2)
+		assert(add2(40) == 42)
+		assert(select(2, pcall(add2, 1)) == 3)`)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestStringMethods(t *testing.T) {
+	ls := New()
+	ls.OpenLibs()
+	// host code adds a method to all strings through their metatable
+	ls.PushString("")
+	if !ls.GetMetatable(-1) {
+		t.Fatal("strings have no metatable")
+	}
+	ls.GetField(-1, "__index")
+	ls.PushGoFunction(func(ls api.LuaState) int {
+		ls.PushInteger(int64(len(ls.CheckString(1)) * 2))
+		return 1
+	})
+	ls.SetField(-2, "twice")
+	ls.SetTop(0)
+	err := ls.DoStringE(`
+		assert(("abc"):upper() == "ABC")
+		local s = "%d-%s"
+		assert(s:format(1, "x") == "1-x")
+		assert(("abc"):twice() == 6)
+		assert(string.twice == ("").twice)`)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,155 @@
+package state
+
+import (
+	"strings"
+	"weak"
+)
+
+// weakness of a table, from the __mode field of its metatable
+const (
+	WEAK_KEYS   = 1 << iota // 'k'
+	WEAK_VALUES             // 'v'
+)
+
+// fields shared by the collectable values: tables, closures, threads and
+// full userdata
+type gcObject struct {
+	id     uint64 // see objectID
+	marked bool   // has a finalizer, see checkFinalizer
+	// values of the weak-keyed tables (ephemerons) using this object as a
+	// key; kept here so that they live exactly as long as their key. The
+	// entries of collected tables are dropped by the next cycle
+	ephemerons map[weak.Pointer[luaTable]]luaValue
+}
+
+func gcObjectOf(val luaValue) *gcObject {
+	switch x := val.(type) {
+	case *luaTable:
+		return &x.gcObject
+	case *closure:
+		return &x.gcObject
+	case *luaState:
+		return &x.gcObject
+	case *userdata:
+		return &x.gcObject
+	}
+	return nil // not collectable
+}
+
+// weak reference to a collectable value, stored in weak tables in place
+// of the value itself
+type weakValue interface {
+	value() luaValue // nil once collected
+}
+
+type weakRef[T any] struct {
+	p weak.Pointer[T]
+}
+
+func (self weakRef[T]) value() luaValue {
+	if v := self.p.Value(); v != nil {
+		return v
+	}
+	return nil
+}
+
+func makeWeak(val luaValue) luaValue {
+	switch x := val.(type) {
+	case *luaTable:
+		return weakRef[luaTable]{weak.Make(x)}
+	case *closure:
+		return weakRef[closure]{weak.Make(x)}
+	case *luaState:
+		return weakRef[luaState]{weak.Make(x)}
+	case *userdata:
+		return weakRef[userdata]{weak.Make(x)}
+	}
+	return val // strings, numbers... are never collected from weak tables
+}
+
+// placeholder for the value of an ephemeron, which is kept by its key
+type ephemeron struct{}
+
+func weakMode(mt *luaTable) int {
+	mode := 0
+	if mt != nil {
+		if s, ok := mt.get("__mode").(string); ok {
+			if strings.IndexByte(s, 'k') >= 0 {
+				mode |= WEAK_KEYS
+			}
+			if strings.IndexByte(s, 'v') >= 0 {
+				mode |= WEAK_VALUES
+			}
+		}
+	}
+	return mode
+}
+
+// changes the weakness of the table, re-storing its entries
+func (self *luaTable) setWeakMode(mode int) {
+	if mode == self.mode {
+		return
+	}
+	var keys, vals []luaValue
+	for k, v, _ := self.next(nil); k != nil; k, v, _ = self.next(k) {
+		keys = append(keys, k)
+		vals = append(vals, v)
+	}
+	for _, k := range keys {
+		self.set(k, nil) // detach ephemerons
+	}
+	self.arr, self._map, self.nodes, self.nDead = nil, nil, nil, 0
+	self.mode = mode
+	for i, k := range keys {
+		self.set(k, vals[i])
+	}
+}
+
+// converts an entry of a weak table to its stored form
+func (self *luaTable) _weaken(key, val luaValue) (luaValue, luaValue) {
+	if self.mode&WEAK_VALUES != 0 {
+		val = makeWeak(val)
+	}
+	if self.mode&WEAK_KEYS != 0 {
+		if obj := gcObjectOf(key); obj != nil {
+			if self.mode&WEAK_VALUES == 0 { // ephemeron
+				val = obj.setEphemeron(self, val)
+			}
+			key = makeWeak(key)
+		}
+	}
+	return key, val
+}
+
+// returns the value stored for key in a weak table, nil if collected
+func (self *luaTable) _strengthen(key, val luaValue) luaValue {
+	switch x := val.(type) {
+	case weakValue:
+		return x.value()
+	case ephemeron:
+		if obj := gcObjectOf(key); obj != nil {
+			return obj.ephemerons[weak.Make(self)]
+		}
+		return nil
+	}
+	return val
+}
+
+// attaches the value of table t to this key object, returns what t stores
+func (self *gcObject) setEphemeron(t *luaTable, val luaValue) luaValue {
+	wt := weak.Make(t)
+	if val == nil {
+		delete(self.ephemerons, wt)
+		return nil
+	}
+	if self.ephemerons == nil {
+		self.ephemerons = make(map[weak.Pointer[luaTable]]luaValue, 1)
+	}
+	for k := range self.ephemerons {
+		if k.Value() == nil { // table was collected
+			delete(self.ephemerons, k)
+		}
+	}
+	self.ephemerons[wt] = val
+	return ephemeron{}
+}
@@ -0,0 +1,55 @@
+package state
+
+import "testing"
+
+func TestDropEphemeronsOfCollectedTables(t *testing.T) {
+	ls := New()
+	ls.OpenLibs()
+	err := ls.DoStringE(`
+		key = {}
+		for i = 1, 100 do
+			local t = setmetatable({}, {__mode = "k"})
+			t[key] = {}
+		end
+		collectgarbage()`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ls.GetGlobal("key")
+	key := ls.stack.get(-1).(*luaTable)
+	// the last table may still be held by a stale register
+	if n := len(key.ephemerons); n > 1 {
+		t.Fatalf("key holds the values of %d collected tables", n)
+	}
+}
+
+func TestWeakValuesWithObjectKeys(t *testing.T) {
+	ls := New()
+	ls.OpenLibs()
+	err := ls.DoStringE(`
+		local t = setmetatable({}, {__mode = "v"})
+		local keys = {{}, function() end, coroutine.create(print)}
+		local vals = {}
+		for i, k in ipairs(keys) do
+			vals[i] = {}
+			t[k] = vals[i]
+			assert(t[k] == vals[i], "index " .. i)
+		end
+		-- reassignment finds the existing entry
+		for i, k in ipairs(keys) do
+			vals[i] = {}
+			t[k] = vals[i]
+			assert(t[k] == vals[i], "reassign " .. i)
+		end
+		local n = 0
+		for k, v in pairs(t) do
+			n = n + 1
+			assert(t[k] == v)
+		end
+		assert(n == #keys, n)
+		t[keys[1]] = nil
+		assert(t[keys[1]] == nil and next(t) ~= nil)`)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
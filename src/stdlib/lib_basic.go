@@ -1 +1,451 @@
 package stdlib
+
+import (
+	"api"
+	"fmt"
+	"strings"
+)
+
+var baseFuncs = api.FuncReg{
+	"print":          basePrint,
+	"assert":         baseAssert,
+	"error":          baseError,
+	"select":         baseSelect,
+	"ipairs":         baseIPairs,
+	"pairs":          basePairs,
+	"next":           baseNext,
+	"load":           baseLoad,
+	"loadfile":       baseLoadFile,
+	"dofile":         baseDoFile,
+	"pcall":          basePCall,
+	"xpcall":         baseXPCall,
+	"getmetatable":   baseGetMetatable,
+	"setmetatable":   baseSetMetatable,
+	"rawequal":       baseRawEqual,
+	"rawlen":         baseRawLen,
+	"rawget":         baseRawGet,
+	"rawset":         baseRawSet,
+	"type":           baseType,
+	"tostring":       baseToString,
+	"tonumber":       baseToNumber,
+	"collectgarbage": baseCollectGarbage,
+}
+
+// lua-5.3.4/src/lbaselib.c#luaopen_base()
+func OpenBaseLib(ls api.LuaState) int {
+	/* open lib into global table */
+	ls.PushGlobalTable()
+	ls.SetFuncs(baseFuncs, 0)
+	/* set global _G */
+	ls.PushValue(-1)
+	ls.SetField(-2, "_G")
+	/* set global _VERSION */
+	ls.PushString("Lua 5.3")
+	ls.SetField(-2, "_VERSION")
+	return 1
+}
+
+// print (···)
+// http://www.lua.org/manual/5.3/manual.html#pdf-print
+// lua-5.3.4/src/lbaselib.c#luaB_print()
+func basePrint(ls api.LuaState) int {
+	nArgs := ls.GetTop()
+	for i := 1; i <= nArgs; i++ {
+		s := ls.ToString2(i)
+		ls.Pop(1)
+		if i > 1 {
+			fmt.Print("\t")
+		}
+		fmt.Print(s)
+	}
+	fmt.Println()
+	return 0
+}
+
+// assert (v [, message])
+// http://www.lua.org/manual/5.3/manual.html#pdf-assert
+// lua-5.3.4/src/lbaselib.c#luaB_assert()
+func baseAssert(ls api.LuaState) int {
+	if ls.ToBoolean(1) { // condition is true?
+		return ls.GetTop() // return all arguments
+	}
+	ls.CheckAny(1)                     // there must be a condition
+	ls.Remove(1)                       // remove it
+	ls.PushString("assertion failed!") // default message
+	ls.SetTop(1)                       // leave only message (default if no other one)
+	return ls.Error()                  // call 'error'
+}
+
+// error (message [, level])
+// http://www.lua.org/manual/5.3/manual.html#pdf-error
+// lua-5.3.4/src/lbaselib.c#luaB_error()
+func baseError(ls api.LuaState) int {
+	level := int(ls.OptInteger(2, 1))
+	ls.SetTop(1)
+	if ls.Type(1) == api.LUA_TSTRING && level > 0 {
+		ls.Where(level) // add extra information
+		ls.PushValue(1)
+		ls.Concat(2)
+	}
+	return ls.Error()
+}
+
+// select (index, ···)
+// http://www.lua.org/manual/5.3/manual.html#pdf-select
+// lua-5.3.4/src/lbaselib.c#luaB_select()
+func baseSelect(ls api.LuaState) int {
+	n := int64(ls.GetTop())
+	if ls.Type(1) == api.LUA_TSTRING && ls.CheckString(1) == "#" {
+		ls.PushInteger(n - 1)
+		return 1
+	}
+	i := ls.CheckInteger(1)
+	if i < 0 {
+		i = n + i
+	} else if i > n {
+		i = n
+	}
+	ls.ArgCheck(1 <= i, 1, "index out of range")
+	return int(n - i)
+}
+
+// ipairs (t)
+// http://www.lua.org/manual/5.3/manual.html#pdf-ipairs
+// lua-5.3.4/src/lbaselib.c#luaB_ipairs()
+func baseIPairs(ls api.LuaState) int {
+	ls.CheckAny(1)
+	ls.PushGoFunction(iPairsAux) // iteration function
+	ls.PushValue(1)              // state
+	ls.PushInteger(0)            // initial value
+	return 3
+}
+
+func iPairsAux(ls api.LuaState) int {
+	i := ls.CheckInteger(2) + 1
+	ls.PushInteger(i)
+	if ls.GetI(1, i) == api.LUA_TNIL {
+		return 1
+	}
+	return 2
+}
+
+// pairs (t)
+// http://www.lua.org/manual/5.3/manual.html#pdf-pairs
+// lua-5.3.4/src/lbaselib.c#luaB_pairs()
+func basePairs(ls api.LuaState) int {
+	ls.CheckAny(1)
+	if ls.GetMetafield(1, "__pairs") == api.LUA_TNIL { // no metamethod?
+		ls.PushGoFunction(baseNext) // will return generator,
+		ls.PushValue(1)             // state,
+		ls.PushNil()                // and initial value
+	} else {
+		ls.PushValue(1) // argument 'self' to metamethod
+		ls.Call(1, 3)   // get 3 values from metamethod
+	}
+	return 3
+}
+
+// next (table [, index])
+// http://www.lua.org/manual/5.3/manual.html#pdf-next
+// lua-5.3.4/src/lbaselib.c#luaB_next()
+func baseNext(ls api.LuaState) int {
+	ls.CheckType(1, api.LUA_TTABLE)
+	ls.SetTop(2) // create a 2nd argument if there isn't one
+	if ls.Next(1) {
+		return 2
+	}
+	ls.PushNil()
+	return 1
+}
+
+// load (chunk [, chunkname [, mode [, env]]])
+// http://www.lua.org/manual/5.3/manual.html#pdf-load
+// lua-5.3.4/src/lbaselib.c#luaB_load()
+func baseLoad(ls api.LuaState) int {
+	var chunk []byte
+	if s, ok := ls.ToStringX(1); ok { // loading a string?
+		chunk = []byte(s)
+	} else { // loading from a reader function
+		ls.CheckType(1, api.LUA_TFUNCTION)
+		chunk = readChunk(ls)
+	}
+	chunkname := ls.OptString(2, string(chunk))
+	mode := ls.OptString(3, "bt")
+	env := 0 // 'env' index or 0 if no 'env'
+	if !ls.IsNone(4) {
+		env = 4
+	}
+	return loadAux(ls, ls.LoadEnv(chunk, chunkname, mode, env))
+}
+
+// calls the reader function at index 1 until it returns nil or an empty
+// string, and concatenates all the pieces
+func readChunk(ls api.LuaState) []byte {
+	var buf []byte
+	for {
+		ls.PushValue(1) // get function
+		ls.Call(0, 1)   // call it
+		if ls.IsNil(-1) {
+			ls.Pop(1) // pop result
+			return buf
+		} else if !ls.IsString(-1) {
+			ls.Error2("reader function must return a string")
+		}
+		piece := ls.ToString(-1)
+		ls.Pop(1)
+		if piece == "" {
+			return buf
+		}
+		buf = append(buf, piece...)
+	}
+}
+
+// lua-5.3.4/src/lbaselib.c#load_aux()
+// the environment, if any, was given to the loaded function by LoadEnv
+func loadAux(ls api.LuaState, status api.ThreadStatus) int {
+	if status == api.LUA_OK {
+		return 1
+	}
+	ls.PushNil()
+	ls.Insert(-2) // put before error message
+	return 2      // return nil plus error message
+}
+
+// loadfile ([filename [, mode [, env]]])
+// http://www.lua.org/manual/5.3/manual.html#pdf-loadfile
+// lua-5.3.4/src/lbaselib.c#luaB_loadfile()
+func baseLoadFile(ls api.LuaState) int {
+	fname := ls.CheckString(1)
+	mode := ls.OptString(2, "bt")
+	env := 0 // 'env' index or 0 if no 'env'
+	if !ls.IsNone(3) {
+		env = 3
+	}
+	return loadAux(ls, ls.LoadFileEnv(fname, mode, env))
+}
+
+// dofile ([filename])
+// http://www.lua.org/manual/5.3/manual.html#pdf-dofile
+// lua-5.3.4/src/lbaselib.c#luaB_dofile()
+func baseDoFile(ls api.LuaState) int {
+	fname := ls.CheckString(1)
+	ls.SetTop(1)
+	if ls.LoadFile(fname) != api.LUA_OK {
+		return ls.Error()
+	}
+	ls.Call(0, api.LUA_MULTRET)
+	return ls.GetTop() - 1
+}
+
+// pcall (f [, arg1, ···])
+// http://www.lua.org/manual/5.3/manual.html#pdf-pcall
+// lua-5.3.4/src/lbaselib.c#luaB_pcall()
+func basePCall(ls api.LuaState) int {
+	ls.CheckAny(1)
+	nArgs := ls.GetTop() - 1
+	status := ls.PCall(nArgs, api.LUA_MULTRET, 0)
+	ls.PushBoolean(status == api.LUA_OK)
+	ls.Insert(1)
+	return ls.GetTop()
+}
+
+// xpcall (f, msgh [, arg1, ···])
+// http://www.lua.org/manual/5.3/manual.html#pdf-xpcall
+// lua-5.3.4/src/lbaselib.c#luaB_xpcall()
+func baseXPCall(ls api.LuaState) int {
+	n := ls.GetTop()
+	ls.CheckType(2, api.LUA_TFUNCTION) // check error function
+	ls.PushBoolean(true)               // first result
+	ls.PushValue(1)                    // function
+	ls.Rotate(3, 2)                    // move them below function's arguments
+	status := ls.PCall(n-2, api.LUA_MULTRET, 2)
+	if status != api.LUA_OK { // error?
+		ls.PushBoolean(false) // first result (false)
+		ls.PushValue(-2)      // error message
+		return 2              // return false, msg
+	}
+	return ls.GetTop() - 2 // return all results
+}
+
+// collectgarbage ([opt [, arg]])
+// http://www.lua.org/manual/5.3/manual.html#pdf-collectgarbage
+// lua-5.3.4/src/lbaselib.c#luaB_collectgarbage()
+func baseCollectGarbage(ls api.LuaState) int {
+	opts := []string{"stop", "restart", "collect",
+		"count", "step", "setpause", "setstepmul",
+		"isrunning"}
+	optsnum := []int{api.LUA_GCSTOP, api.LUA_GCRESTART, api.LUA_GCCOLLECT,
+		api.LUA_GCCOUNT, api.LUA_GCSTEP, api.LUA_GCSETPAUSE, api.LUA_GCSETSTEPMUL,
+		api.LUA_GCISRUNNING}
+	o := optsnum[ls.CheckOption(1, "collect", opts)]
+	ex := int(ls.OptInteger(2, 0))
+	res := ls.GC(o, ex)
+	switch o {
+	case api.LUA_GCCOUNT:
+		b := ls.GC(api.LUA_GCCOUNTB, 0)
+		ls.PushNumber(float64(res) + float64(b)/1024)
+	case api.LUA_GCSTEP, api.LUA_GCISRUNNING:
+		ls.PushBoolean(res != 0)
+	default:
+		ls.PushInteger(int64(res))
+	}
+	return 1
+}
+
+// getmetatable (object)
+// http://www.lua.org/manual/5.3/manual.html#pdf-getmetatable
+// lua-5.3.4/src/lbaselib.c#luaB_getmetatable()
+func baseGetMetatable(ls api.LuaState) int {
+	ls.CheckAny(1)
+	if !ls.GetMetatable(1) {
+		ls.PushNil()
+		return 1 // no metatable
+	}
+	ls.GetMetafield(1, "__metatable")
+	return 1 // returns either __metatable field (if present) or metatable
+}
+
+// setmetatable (table, metatable)
+// http://www.lua.org/manual/5.3/manual.html#pdf-setmetatable
+// lua-5.3.4/src/lbaselib.c#luaB_setmetatable()
+func baseSetMetatable(ls api.LuaState) int {
+	t := ls.Type(2)
+	ls.CheckType(1, api.LUA_TTABLE)
+	ls.ArgCheck(t == api.LUA_TNIL || t == api.LUA_TTABLE, 2,
+		"nil or table expected")
+	if ls.GetMetafield(1, "__metatable") != api.LUA_TNIL {
+		return ls.Error2("cannot change a protected metatable")
+	}
+	ls.SetTop(2)
+	ls.SetMetatable(1)
+	return 1
+}
+
+// rawequal (v1, v2)
+// http://www.lua.org/manual/5.3/manual.html#pdf-rawequal
+// lua-5.3.4/src/lbaselib.c#luaB_rawequal()
+func baseRawEqual(ls api.LuaState) int {
+	ls.CheckAny(1)
+	ls.CheckAny(2)
+	ls.PushBoolean(ls.RawEqual(1, 2))
+	return 1
+}
+
+// rawlen (v)
+// http://www.lua.org/manual/5.3/manual.html#pdf-rawlen
+// lua-5.3.4/src/lbaselib.c#luaB_rawlen()
+func baseRawLen(ls api.LuaState) int {
+	t := ls.Type(1)
+	ls.ArgCheck(t == api.LUA_TTABLE || t == api.LUA_TSTRING, 1,
+		"table or string expected")
+	ls.PushInteger(int64(ls.RawLen(1)))
+	return 1
+}
+
+// rawget (table, index)
+// http://www.lua.org/manual/5.3/manual.html#pdf-rawget
+// lua-5.3.4/src/lbaselib.c#luaB_rawget()
+func baseRawGet(ls api.LuaState) int {
+	ls.CheckType(1, api.LUA_TTABLE)
+	ls.CheckAny(2)
+	ls.SetTop(2)
+	ls.RawGet(1)
+	return 1
+}
+
+// rawset (table, index, value)
+// http://www.lua.org/manual/5.3/manual.html#pdf-rawset
+// lua-5.3.4/src/lbaselib.c#luaB_rawset()
+func baseRawSet(ls api.LuaState) int {
+	ls.CheckType(1, api.LUA_TTABLE)
+	ls.CheckAny(2)
+	ls.CheckAny(3)
+	ls.SetTop(3)
+	ls.RawSet(1)
+	return 1
+}
+
+// type (v)
+// http://www.lua.org/manual/5.3/manual.html#pdf-type
+// lua-5.3.4/src/lbaselib.c#luaB_type()
+func baseType(ls api.LuaState) int {
+	t := ls.Type(1)
+	ls.ArgCheck(t != api.LUA_TNONE, 1, "value expected")
+	ls.PushString(ls.TypeName(t))
+	return 1
+}
+
+// tostring (v)
+// http://www.lua.org/manual/5.3/manual.html#pdf-tostring
+// lua-5.3.4/src/lbaselib.c#luaB_tostring()
+func baseToString(ls api.LuaState) int {
+	ls.CheckAny(1)
+	ls.ToString2(1)
+	return 1
+}
+
+// tonumber (e [, base])
+// http://www.lua.org/manual/5.3/manual.html#pdf-tonumber
+// lua-5.3.4/src/lbaselib.c#luaB_tonumber()
+func baseToNumber(ls api.LuaState) int {
+	if ls.IsNoneOrNil(2) { // standard conversion?
+		if ls.Type(1) == api.LUA_TNUMBER {
+			ls.SetTop(1) // yes; return it
+			return 1
+		}
+		if s, ok := ls.ToStringX(1); ok && ls.StringToNumber(s) {
+			return 1 // successful conversion to number
+		}
+		ls.CheckAny(1) // (but there must be some parameter)
+	} else {
+		base := ls.CheckInteger(2)
+		ls.CheckType(1, api.LUA_TSTRING) // no numbers as strings
+		s := ls.ToString(1)
+		ls.ArgCheck(2 <= base && base <= 36, 2, "base out of range")
+		if n, ok := strToInt(s, int(base)); ok {
+			ls.PushInteger(n)
+			return 1
+		} // else not a number
+	}
+	ls.PushNil() // not a number
+	return 1
+}
+
+// lua-5.3.4/src/lbaselib.c#l_str2int
+// converts a numeral in the given base, surrounded by optional spaces;
+// the result wraps around on overflow
+func strToInt(s string, base int) (int64, bool) {
+	s = strings.Trim(s, " \f\n\r\t\v") // skip initial and trailing spaces
+	neg := false
+	if strings.HasPrefix(s, "-") {
+		s = s[1:]
+		neg = true
+	} else if strings.HasPrefix(s, "+") {
+		s = s[1:]
+	}
+	if s == "" { // no digit?
+		return 0, false
+	}
+	var n uint64
+	for i := 0; i < len(s); i++ {
+		var digit int
+		switch c := s[i]; {
+		case '0' <= c && c <= '9':
+			digit = int(c - '0')
+		case 'a' <= c && c <= 'z':
+			digit = int(c-'a') + 10
+		case 'A' <= c && c <= 'Z':
+			digit = int(c-'A') + 10
+		default:
+			return 0, false
+		}
+		if digit >= base {
+			return 0, false // invalid numeral
+		}
+		n = n*uint64(base) + uint64(digit)
+	}
+	if neg {
+		return int64(0 - n), true
+	}
+	return int64(n), true
+}
@@ -0,0 +1,157 @@
+package stdlib
+
+import "api"
+
+var coFuncs = api.FuncReg{
+	"create":      coCreate,
+	"resume":      coResume,
+	"yield":       coYield,
+	"status":      coStatus,
+	"isyieldable": coYieldable,
+	"running":     coRunning,
+	"wrap":        coWrap,
+}
+
+// lua-5.3.4/src/lcorolib.c#luaopen_coroutine()
+func OpenCoroutineLib(ls api.LuaState) int {
+	ls.NewLib(coFuncs)
+	return 1
+}
+
+func getCo(ls api.LuaState) api.LuaState {
+	co := ls.ToThread(1)
+	ls.ArgCheck(co != nil, 1, "coroutine expected")
+	return co
+}
+
+// coroutine.create (f)
+// http://www.lua.org/manual/5.3/manual.html#pdf-coroutine.create
+// lua-5.3.4/src/lcorolib.c#luaB_cocreate()
+func coCreate(ls api.LuaState) int {
+	ls.CheckType(1, api.LUA_TFUNCTION)
+	co := ls.NewThread()
+	ls.PushValue(1) // move function to top
+	ls.XMove(co, 1) // move function from ls to co
+	return 1
+}
+
+// coroutine.resume (co [, val1, ···])
+// http://www.lua.org/manual/5.3/manual.html#pdf-coroutine.resume
+// lua-5.3.4/src/lcorolib.c#luaB_coresume()
+func coResume(ls api.LuaState) int {
+	co := getCo(ls)
+	if r := auxResume(ls, co, ls.GetTop()-1); r < 0 {
+		ls.PushBoolean(false)
+		ls.Insert(-2)
+		return 2 // return false + error message
+	} else {
+		ls.PushBoolean(true)
+		ls.Insert(-(r + 1))
+		return r + 1 // return true + 'resume' returns
+	}
+}
+
+// lua-5.3.4/src/lcorolib.c#auxresume()
+func auxResume(ls, co api.LuaState, nArgs int) int {
+	if !co.CheckStack(nArgs) {
+		ls.PushString("too many arguments to resume")
+		return -1 // error flag
+	}
+	if co.Status() == api.LUA_OK && co.GetTop() == 0 {
+		ls.PushString("cannot resume dead coroutine")
+		return -1 // error flag
+	}
+	ls.XMove(co, nArgs)
+	status := co.Resume(ls, nArgs)
+	if status == api.LUA_OK || status == api.LUA_YIELD {
+		nRes := co.GetTop()
+		if !ls.CheckStack(nRes + 1) {
+			co.Pop(nRes) // remove results anyway
+			ls.PushString("too many results to resume")
+			return -1 // error flag
+		}
+		co.XMove(ls, nRes) // move yielded values
+		return nRes
+	} else {
+		co.XMove(ls, 1) // move error message
+		return -1       // error flag
+	}
+}
+
+// coroutine.wrap (f)
+// http://www.lua.org/manual/5.3/manual.html#pdf-coroutine.wrap
+// lua-5.3.4/src/lcorolib.c#luaB_cowrap()
+func coWrap(ls api.LuaState) int {
+	coCreate(ls)
+	ls.PushGoClosure(auxWrap, 1)
+	return 1
+}
+
+// lua-5.3.4/src/lcorolib.c#auxwrap()
+func auxWrap(ls api.LuaState) int {
+	co := ls.ToThread(ls.UpvalueIndex(1))
+	r := auxResume(ls, co, ls.GetTop())
+	if r < 0 {
+		if ls.Type(-1) == api.LUA_TSTRING { // error object is a string?
+			ls.Where(1) // get extra info
+			ls.Insert(-2)
+			ls.Concat(2)
+		}
+		return ls.Error() // propagate error
+	}
+	return r
+}
+
+// coroutine.yield (···)
+// http://www.lua.org/manual/5.3/manual.html#pdf-coroutine.yield
+// lua-5.3.4/src/lcorolib.c#luaB_yield()
+func coYield(ls api.LuaState) int {
+	return ls.Yield(ls.GetTop())
+}
+
+// coroutine.status (co)
+// http://www.lua.org/manual/5.3/manual.html#pdf-coroutine.status
+// lua-5.3.4/src/lcorolib.c#luaB_costatus()
+func coStatus(ls api.LuaState) int {
+	co := getCo(ls)
+	ls.PushString(auxStatus(ls, co))
+	return 1
+}
+
+// lua-5.3.4/src/lcorolib.c#auxstatus()
+func auxStatus(ls, co api.LuaState) string {
+	if ls == co {
+		return "running"
+	}
+	switch co.Status() {
+	case api.LUA_YIELD:
+		return "suspended"
+	case api.LUA_OK:
+		if co.GetStack(0, nil) { // does it have frames?
+			return "normal" // it is running
+		} else if co.GetTop() == 0 {
+			return "dead"
+		} else {
+			return "suspended" // initial state
+		}
+	default: // some error occurred
+		return "dead"
+	}
+}
+
+// coroutine.isyieldable ()
+// http://www.lua.org/manual/5.3/manual.html#pdf-coroutine.isyieldable
+// lua-5.3.4/src/lcorolib.c#luaB_yieldable()
+func coYieldable(ls api.LuaState) int {
+	ls.PushBoolean(ls.IsYieldable())
+	return 1
+}
+
+// coroutine.running ()
+// http://www.lua.org/manual/5.3/manual.html#pdf-coroutine.running
+// lua-5.3.4/src/lcorolib.c#luaB_corunning()
+func coRunning(ls api.LuaState) int {
+	isMain := ls.PushThread()
+	ls.PushBoolean(isMain)
+	return 2
+}
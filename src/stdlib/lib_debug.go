@@ -0,0 +1,474 @@
+package stdlib
+
+import (
+	"api"
+	"bufio"
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+)
+
+// key, in the registry, for table of hooks
+const HOOKKEY = "_HKEY"
+
+var dbLib = api.FuncReg{
+	"debug":        dbDebug,
+	"getuservalue": dbGetUservalue,
+	"gethook":      dbGetHook,
+	"getinfo":      dbGetInfo,
+	"getlocal":     dbGetLocal,
+	"getregistry":  dbGetRegistry,
+	"getmetatable": dbGetMetatable,
+	"getupvalue":   dbGetUpvalue,
+	"upvaluejoin":  dbUpvalueJoin,
+	"upvalueid":    dbUpvalueID,
+	"setuservalue": dbSetUservalue,
+	"sethook":      dbSetHook,
+	"setlocal":     dbSetLocal,
+	"setmetatable": dbSetMetatable,
+	"setupvalue":   dbSetUpvalue,
+	"traceback":    dbTraceback,
+}
+
+// lua-5.3.4/src/ldblib.c#luaopen_debug()
+func OpenDebugLib(ls api.LuaState) int {
+	ls.NewLib(dbLib)
+	return 1
+}
+
+// lua-5.3.4/src/ldblib.c#checkstack()
+// if ls1 != ls, ls1 can be in any state, and therefore there are no
+// guarantees about its stack space
+func checkStack(ls, ls1 api.LuaState, n int) {
+	if ls != ls1 && !ls1.CheckStack(n) {
+		ls.Error2("stack overflow")
+	}
+}
+
+// lua-5.3.4/src/ldblib.c#getthread()
+// returns the thread given as optional first argument (or ls itself) and
+// the number of arguments it takes
+func getThread(ls api.LuaState) (api.LuaState, int) {
+	if ls.Type(1) == api.LUA_TTHREAD {
+		return ls.ToThread(1), 1
+	}
+	return ls, 0 // function will operate over current thread
+}
+
+// debug.getregistry ()
+// http://www.lua.org/manual/5.3/manual.html#pdf-debug.getregistry
+// lua-5.3.4/src/ldblib.c#db_getregistry()
+func dbGetRegistry(ls api.LuaState) int {
+	ls.PushValue(api.LUA_REGISTRYINDEX)
+	return 1
+}
+
+// debug.getmetatable (value)
+// http://www.lua.org/manual/5.3/manual.html#pdf-debug.getmetatable
+// lua-5.3.4/src/ldblib.c#db_getmetatable()
+func dbGetMetatable(ls api.LuaState) int {
+	ls.CheckAny(1)
+	if !ls.GetMetatable(1) {
+		ls.PushNil() // no metatable
+	}
+	return 1
+}
+
+// debug.setmetatable (value, table)
+// http://www.lua.org/manual/5.3/manual.html#pdf-debug.setmetatable
+// lua-5.3.4/src/ldblib.c#db_setmetatable()
+func dbSetMetatable(ls api.LuaState) int {
+	t := ls.Type(2)
+	ls.ArgCheck(t == api.LUA_TNIL || t == api.LUA_TTABLE, 2, "nil or table expected")
+	ls.SetTop(2)
+	ls.SetMetatable(1)
+	return 1 // return 1st argument
+}
+
+// debug.getuservalue (u)
+// http://www.lua.org/manual/5.3/manual.html#pdf-debug.getuservalue
+// lua-5.3.4/src/ldblib.c#db_getuservalue()
+func dbGetUservalue(ls api.LuaState) int {
+	if ls.Type(1) != api.LUA_TUSERDATA {
+		ls.PushNil()
+	} else {
+		ls.GetUservalue(1)
+	}
+	return 1
+}
+
+// debug.setuservalue (udata, value)
+// http://www.lua.org/manual/5.3/manual.html#pdf-debug.setuservalue
+// lua-5.3.4/src/ldblib.c#db_setuservalue()
+func dbSetUservalue(ls api.LuaState) int {
+	ls.CheckType(1, api.LUA_TUSERDATA)
+	ls.CheckAny(2)
+	ls.SetTop(2)
+	ls.SetUservalue(1)
+	return 1
+}
+
+// lua-5.3.4/src/ldblib.c#settabss()
+func setTabSS(ls api.LuaState, k, v string) {
+	ls.PushString(v)
+	ls.SetField(-2, k)
+}
+
+// lua-5.3.4/src/ldblib.c#settabsi()
+func setTabSI(ls api.LuaState, k string, v int) {
+	ls.PushInteger(int64(v))
+	ls.SetField(-2, k)
+}
+
+// lua-5.3.4/src/ldblib.c#settabsb()
+func setTabSB(ls api.LuaState, k string, v bool) {
+	ls.PushBoolean(v)
+	ls.SetField(-2, k)
+}
+
+// lua-5.3.4/src/ldblib.c#treatstackoption()
+// In function 'dbGetInfo', the information is collected in ls1 and the
+// result table is in ls: moves the value on the top of ls1 into the table
+func treatStackOption(ls, ls1 api.LuaState, fname string) {
+	if ls == ls1 {
+		ls.Rotate(-2, 1) // exchange object and table
+	} else {
+		ls1.XMove(ls, 1) // move object to the "main" stack
+	}
+	ls.SetField(-2, fname) // put object into table
+}
+
+// debug.getinfo ([thread,] f [, what])
+// http://www.lua.org/manual/5.3/manual.html#pdf-debug.getinfo
+// lua-5.3.4/src/ldblib.c#db_getinfo()
+func dbGetInfo(ls api.LuaState) int {
+	var ar api.Debug
+	ls1, arg := getThread(ls)
+	options := ls.OptString(arg+2, "flnStu")
+	if strings.HasPrefix(options, ">") { // reserved for functions given below
+		return ls.ArgError(arg+2, "invalid option")
+	}
+	checkStack(ls, ls1, 3)
+	if ls.Type(arg+1) == api.LUA_TFUNCTION { // info about a function?
+		options = ">" + options // add '>' to 'options'
+		ls.PushValue(arg + 1)   // move function to 'ls1' stack
+		ls.XMove(ls1, 1)
+	} else { // stack level
+		if !ls1.GetStack(int(ls.CheckInteger(arg+1)), &ar) {
+			ls.PushNil() // level out of range
+			return 1
+		}
+	}
+	if !ls1.GetInfo(options, &ar) {
+		return ls.ArgError(arg+2, "invalid option")
+	}
+	ls.NewTable() // table to collect results
+	if strings.ContainsRune(options, 'S') {
+		setTabSS(ls, "source", ar.Source)
+		setTabSS(ls, "short_src", ar.ShortSrc)
+		setTabSI(ls, "linedefined", ar.LineDefined)
+		setTabSI(ls, "lastlinedefined", ar.LastLineDefined)
+		setTabSS(ls, "what", ar.What)
+	}
+	if strings.ContainsRune(options, 'l') {
+		setTabSI(ls, "currentline", ar.CurrentLine)
+	}
+	if strings.ContainsRune(options, 'u') {
+		setTabSI(ls, "nups", ar.NUps)
+		setTabSI(ls, "nparams", ar.NParams)
+		setTabSB(ls, "isvararg", ar.IsVararg)
+	}
+	if strings.ContainsRune(options, 'n') {
+		if ar.NameWhat != "" { // no name otherwise
+			setTabSS(ls, "name", ar.Name)
+		}
+		setTabSS(ls, "namewhat", ar.NameWhat)
+	}
+	if strings.ContainsRune(options, 't') {
+		setTabSB(ls, "istailcall", ar.IsTailCall)
+	}
+	if strings.ContainsRune(options, 'L') {
+		treatStackOption(ls, ls1, "activelines")
+	}
+	if strings.ContainsRune(options, 'f') {
+		treatStackOption(ls, ls1, "func")
+	}
+	return 1 // return table
+}
+
+// debug.getlocal ([thread,] f, local)
+// http://www.lua.org/manual/5.3/manual.html#pdf-debug.getlocal
+// lua-5.3.4/src/ldblib.c#db_getlocal()
+func dbGetLocal(ls api.LuaState) int {
+	var ar api.Debug
+	ls1, arg := getThread(ls)
+	nvar := int(ls.CheckInteger(arg + 2))    // local-variable index
+	if ls.Type(arg+1) == api.LUA_TFUNCTION { // function argument?
+		ls.PushValue(arg + 1) // push function
+		if name := ls.GetLocal(nil, nvar); name != "" {
+			ls.PushString(name) // push local name
+		} else {
+			ls.PushNil()
+		}
+		return 1 // return only name (there is no value)
+	}
+	// stack-level argument
+	level := int(ls.CheckInteger(arg + 1))
+	if !ls1.GetStack(level, &ar) { // out of range?
+		return ls.ArgError(arg+1, "level out of range")
+	}
+	checkStack(ls, ls1, 1)
+	if name := ls1.GetLocal(&ar, nvar); name != "" {
+		ls1.XMove(ls, 1)    // move local value
+		ls.PushString(name) // push name
+		ls.Rotate(-2, 1)    // re-order
+		return 2
+	}
+	ls.PushNil() // no name (nor value)
+	return 1
+}
+
+// debug.setlocal ([thread,] level, local, value)
+// http://www.lua.org/manual/5.3/manual.html#pdf-debug.setlocal
+// lua-5.3.4/src/ldblib.c#db_setlocal()
+func dbSetLocal(ls api.LuaState) int {
+	var ar api.Debug
+	ls1, arg := getThread(ls)
+	level := int(ls.CheckInteger(arg + 1))
+	nvar := int(ls.CheckInteger(arg + 2))
+	if !ls1.GetStack(level, &ar) { // out of range?
+		return ls.ArgError(arg+1, "level out of range")
+	}
+	ls.CheckAny(arg + 3)
+	ls.SetTop(arg + 3)
+	checkStack(ls, ls1, 1)
+	ls.XMove(ls1, 1)
+	name := ls1.SetLocal(&ar, nvar)
+	if name == "" {
+		ls1.Pop(1) // pop value (if not popped by 'SetLocal')
+		ls.PushNil()
+	} else {
+		ls.PushString(name)
+	}
+	return 1
+}
+
+// lua-5.3.4/src/ldblib.c#auxupvalue()
+// get (if 'get' is true) or set an upvalue from a closure
+func auxUpvalue(ls api.LuaState, get bool) int {
+	n := int(ls.CheckInteger(2)) // upvalue index
+	ls.CheckType(1, api.LUA_TFUNCTION)
+	var name string
+	var ok bool
+	if get {
+		name, ok = ls.GetUpvalue(1, n)
+	} else {
+		name, ok = ls.SetUpvalue(1, n)
+	}
+	if !ok {
+		return 0
+	}
+	ls.PushString(name)
+	if get {
+		ls.Insert(-2)
+		return 2
+	}
+	return 1
+}
+
+// debug.getupvalue (f, up)
+// http://www.lua.org/manual/5.3/manual.html#pdf-debug.getupvalue
+// lua-5.3.4/src/ldblib.c#db_getupvalue()
+func dbGetUpvalue(ls api.LuaState) int {
+	return auxUpvalue(ls, true)
+}
+
+// debug.setupvalue (f, up, value)
+// http://www.lua.org/manual/5.3/manual.html#pdf-debug.setupvalue
+// lua-5.3.4/src/ldblib.c#db_setupvalue()
+func dbSetUpvalue(ls api.LuaState) int {
+	ls.CheckAny(3)
+	return auxUpvalue(ls, false)
+}
+
+// lua-5.3.4/src/ldblib.c#checkupval()
+// checks whether a given upvalue from a given closure exists and
+// returns its index
+func checkUpval(ls api.LuaState, argf, argnup int) int {
+	nup := int(ls.CheckInteger(argnup))   // upvalue index
+	ls.CheckType(argf, api.LUA_TFUNCTION) // closure
+	_, ok := ls.GetUpvalue(argf, nup)
+	ls.ArgCheck(ok, argnup, "invalid upvalue index")
+	return nup
+}
+
+// debug.upvalueid (f, n)
+// http://www.lua.org/manual/5.3/manual.html#pdf-debug.upvalueid
+// lua-5.3.4/src/ldblib.c#db_upvalueid()
+func dbUpvalueID(ls api.LuaState) int {
+	n := checkUpval(ls, 1, 2)
+	ls.PushLightUserData(ls.UpvalueID(1, n))
+	return 1
+}
+
+// debug.upvaluejoin (f1, n1, f2, n2)
+// http://www.lua.org/manual/5.3/manual.html#pdf-debug.upvaluejoin
+// lua-5.3.4/src/ldblib.c#db_upvaluejoin()
+func dbUpvalueJoin(ls api.LuaState) int {
+	n1 := checkUpval(ls, 1, 2)
+	n2 := checkUpval(ls, 3, 4)
+	ls.ArgCheck(!ls.IsGoFunction(1), 1, "Lua function expected")
+	ls.ArgCheck(!ls.IsGoFunction(3), 3, "Lua function expected")
+	ls.UpvalueJoin(1, n1, 3, n2)
+	return 0
+}
+
+var hookNames = []string{"call", "return", "line", "count", "tail call"}
+
+// lua-5.3.4/src/ldblib.c#hookf()
+// Call hook function registered at hook table for the current
+// thread (if there is one)
+func hookF(ls api.LuaState, ar *api.Debug) {
+	ls.GetField(api.LUA_REGISTRYINDEX, HOOKKEY)
+	ls.PushThread()
+	if ls.RawGet(-2) == api.LUA_TFUNCTION { // is there a hook function?
+		ls.PushString(hookNames[ar.Event]) // push event name
+		if ar.CurrentLine >= 0 {
+			ls.PushInteger(int64(ar.CurrentLine)) // push current line
+		} else {
+			ls.PushNil()
+		}
+		ls.Call(2, 0) // call hook function
+	}
+}
+
+func isHookF(hook api.Hook) bool {
+	return reflect.ValueOf(hook).Pointer() == reflect.ValueOf(hookF).Pointer()
+}
+
+// lua-5.3.4/src/ldblib.c#makemask()
+// Convert a string mask (for 'sethook') into a bit mask
+func makeMask(smask string, count int) int {
+	mask := 0
+	if strings.ContainsRune(smask, 'c') {
+		mask |= api.LUA_MASKCALL
+	}
+	if strings.ContainsRune(smask, 'r') {
+		mask |= api.LUA_MASKRET
+	}
+	if strings.ContainsRune(smask, 'l') {
+		mask |= api.LUA_MASKLINE
+	}
+	if count > 0 {
+		mask |= api.LUA_MASKCOUNT
+	}
+	return mask
+}
+
+// lua-5.3.4/src/ldblib.c#unmakemask()
+// Convert a bit mask (for 'gethook') into a string mask
+func unmakeMask(mask int) string {
+	smask := ""
+	if mask&api.LUA_MASKCALL != 0 {
+		smask += "c"
+	}
+	if mask&api.LUA_MASKRET != 0 {
+		smask += "r"
+	}
+	if mask&api.LUA_MASKLINE != 0 {
+		smask += "l"
+	}
+	return smask
+}
+
+// debug.sethook ([thread,] hook, mask [, count])
+// http://www.lua.org/manual/5.3/manual.html#pdf-debug.sethook
+// lua-5.3.4/src/ldblib.c#db_sethook()
+func dbSetHook(ls api.LuaState) int {
+	var mask, count int
+	var fn api.Hook
+	ls1, arg := getThread(ls)
+	if ls.IsNoneOrNil(arg + 1) { // no hook?
+		ls.SetTop(arg + 1) // turn off hooks
+	} else {
+		smask := ls.CheckString(arg + 2)
+		ls.CheckType(arg+1, api.LUA_TFUNCTION)
+		count = int(ls.OptInteger(arg+3, 0))
+		fn, mask = hookF, makeMask(smask, count)
+	}
+	if !ls.GetSubTable(api.LUA_REGISTRYINDEX, HOOKKEY) { // creating hook table?
+		ls.PushString("k")
+		ls.SetField(-2, "__mode") // hooktable.__mode = "k"
+		ls.PushValue(-1)
+		ls.SetMetatable(-2) // setmetatable(hooktable) = hooktable
+	}
+	checkStack(ls, ls1, 1)
+	ls1.PushThread()
+	ls1.XMove(ls, 1)      // key (thread)
+	ls.PushValue(arg + 1) // value (hook function)
+	ls.RawSet(-3)         // hooktable[ls1] = new Lua hook
+	ls1.SetHook(fn, mask, count)
+	return 0
+}
+
+// debug.gethook ([thread])
+// http://www.lua.org/manual/5.3/manual.html#pdf-debug.gethook
+// lua-5.3.4/src/ldblib.c#db_gethook()
+func dbGetHook(ls api.LuaState) int {
+	ls1, _ := getThread(ls)
+	mask := ls1.GetHookMask()
+	if hook := ls1.GetHook(); hook == nil { // no hook?
+		return 0
+	} else if !isHookF(hook) { // external hook?
+		ls.PushString("external hook")
+	} else { // hook table must exist
+		ls.GetField(api.LUA_REGISTRYINDEX, HOOKKEY)
+		checkStack(ls, ls1, 1)
+		ls1.PushThread()
+		ls1.XMove(ls, 1)
+		ls.RawGet(-2) // 1st result = hooktable[ls1]
+		ls.Remove(-2) // remove hook table
+	}
+	ls.PushString(unmakeMask(mask))           // 2nd result = mask
+	ls.PushInteger(int64(ls1.GetHookCount())) // 3rd result = count
+	return 3
+}
+
+// debug.debug ()
+// http://www.lua.org/manual/5.3/manual.html#pdf-debug.debug
+// lua-5.3.4/src/ldblib.c#db_debug()
+func dbDebug(ls api.LuaState) int {
+	stdin := bufio.NewReader(os.Stdin)
+	for {
+		fmt.Fprint(os.Stderr, "lua_debug> ")
+		line, err := stdin.ReadString('\n')
+		if err != nil || line == "cont\n" {
+			return 0
+		}
+		if ls.Load([]byte(line), "=(debug command)", "bt") != api.LUA_OK ||
+			ls.PCall(0, 0, 0) != api.LUA_OK {
+			fmt.Fprintln(os.Stderr, ls.ToString(-1))
+		}
+		ls.SetTop(0) // remove eventual returns
+	}
+}
+
+// debug.traceback ([thread,] [message [, level]])
+// http://www.lua.org/manual/5.3/manual.html#pdf-debug.traceback
+// lua-5.3.4/src/ldblib.c#db_traceback()
+func dbTraceback(ls api.LuaState) int {
+	ls1, arg := getThread(ls)
+	msg, ok := ls.ToStringX(arg + 1)
+	if !ok && !ls.IsNoneOrNil(arg+1) { // non-string 'msg'?
+		ls.PushValue(arg + 1) // return it untouched
+	} else {
+		level := 0
+		if ls == ls1 {
+			level = 1
+		}
+		level = int(ls.OptInteger(arg+2, int64(level)))
+		ls.Traceback(ls1, msg, level)
+	}
+	return 1
+}
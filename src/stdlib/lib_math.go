@@ -0,0 +1,332 @@
+package stdlib
+
+import (
+	"api"
+	"math"
+	"number"
+)
+
+var mathLib = api.FuncReg{
+	"abs":        mathAbs,
+	"ceil":       mathCeil,
+	"floor":      mathFloor,
+	"fmod":       mathFmod,
+	"modf":       mathModf,
+	"sqrt":       mathSqrt,
+	"exp":        mathExp,
+	"log":        mathLog,
+	"sin":        mathSin,
+	"cos":        mathCos,
+	"tan":        mathTan,
+	"asin":       mathAsin,
+	"acos":       mathAcos,
+	"atan":       mathAtan,
+	"tointeger":  mathToInt,
+	"type":       mathType,
+	"ult":        mathUlt,
+	"max":        mathMax,
+	"min":        mathMin,
+	"random":     mathRandom,
+	"randomseed": mathRandomSeed,
+}
+
+// lua-5.3.4/src/lmathlib.c#luaopen_math()
+func OpenMathLib(ls api.LuaState) int {
+	ls.NewLib(mathLib)
+	ls.PushNumber(math.Pi)
+	ls.SetField(-2, "pi")
+	ls.PushNumber(math.Inf(1))
+	ls.SetField(-2, "huge")
+	ls.PushInteger(math.MaxInt64)
+	ls.SetField(-2, "maxinteger")
+	ls.PushInteger(math.MinInt64)
+	ls.SetField(-2, "mininteger")
+	return 1
+}
+
+// math.abs (x)
+// http://www.lua.org/manual/5.3/manual.html#pdf-math.abs
+// lua-5.3.4/src/lmathlib.c#math_abs()
+func mathAbs(ls api.LuaState) int {
+	if ls.IsInteger(1) {
+		n := ls.ToInteger(1)
+		if n < 0 {
+			n = 0 - n // wraps around for mininteger, as in Lua
+		}
+		ls.PushInteger(n)
+	} else {
+		ls.PushNumber(math.Abs(ls.CheckNumber(1)))
+	}
+	return 1
+}
+
+// math.ceil (x)
+// http://www.lua.org/manual/5.3/manual.html#pdf-math.ceil
+// lua-5.3.4/src/lmathlib.c#math_ceil()
+func mathCeil(ls api.LuaState) int {
+	if ls.IsInteger(1) {
+		ls.SetTop(1) // integer is its own ceil
+	} else {
+		pushNumInt(ls, math.Ceil(ls.CheckNumber(1)))
+	}
+	return 1
+}
+
+// math.floor (x)
+// http://www.lua.org/manual/5.3/manual.html#pdf-math.floor
+// lua-5.3.4/src/lmathlib.c#math_floor()
+func mathFloor(ls api.LuaState) int {
+	if ls.IsInteger(1) {
+		ls.SetTop(1) // integer is its own floor
+	} else {
+		pushNumInt(ls, math.Floor(ls.CheckNumber(1)))
+	}
+	return 1
+}
+
+// pushes d as an integer if it has an exact representation, as a float
+// otherwise
+func pushNumInt(ls api.LuaState, d float64) {
+	if i, ok := number.FloatToInteger(d); ok { // does 'd' fit in an integer?
+		ls.PushInteger(i) // result is integer
+	} else {
+		ls.PushNumber(d) // result is float
+	}
+}
+
+// math.fmod (x, y)
+// http://www.lua.org/manual/5.3/manual.html#pdf-math.fmod
+// lua-5.3.4/src/lmathlib.c#math_fmod()
+func mathFmod(ls api.LuaState) int {
+	if ls.IsInteger(1) && ls.IsInteger(2) {
+		d := ls.ToInteger(2)
+		if uint64(d)+1 <= 1 { // special cases: -1 or 0
+			ls.ArgCheck(d != 0, 2, "zero")
+			ls.PushInteger(0) // avoid overflow with 0x80000... / -1
+		} else {
+			ls.PushInteger(ls.ToInteger(1) % d) // C semantics: sign of the dividend
+		}
+	} else {
+		ls.PushNumber(math.Mod(ls.CheckNumber(1), ls.CheckNumber(2)))
+	}
+	return 1
+}
+
+// math.modf (x)
+// http://www.lua.org/manual/5.3/manual.html#pdf-math.modf
+// lua-5.3.4/src/lmathlib.c#math_modf()
+func mathModf(ls api.LuaState) int {
+	if ls.IsInteger(1) {
+		ls.SetTop(1)     // number is its own integer part
+		ls.PushNumber(0) // no fractional part
+	} else {
+		n := ls.CheckNumber(1)
+		// integer part (rounds toward zero)
+		var ip float64
+		if n < 0 {
+			ip = math.Ceil(n)
+		} else {
+			ip = math.Floor(n)
+		}
+		pushNumInt(ls, ip)
+		// fractional part (test needed for inf/-inf)
+		if n == ip {
+			ls.PushNumber(0)
+		} else {
+			ls.PushNumber(n - ip)
+		}
+	}
+	return 2
+}
+
+// math.sqrt (x)
+// http://www.lua.org/manual/5.3/manual.html#pdf-math.sqrt
+func mathSqrt(ls api.LuaState) int {
+	ls.PushNumber(math.Sqrt(ls.CheckNumber(1)))
+	return 1
+}
+
+// math.exp (x)
+// http://www.lua.org/manual/5.3/manual.html#pdf-math.exp
+func mathExp(ls api.LuaState) int {
+	ls.PushNumber(math.Exp(ls.CheckNumber(1)))
+	return 1
+}
+
+// math.log (x [, base])
+// http://www.lua.org/manual/5.3/manual.html#pdf-math.log
+// lua-5.3.4/src/lmathlib.c#math_log()
+func mathLog(ls api.LuaState) int {
+	x := ls.CheckNumber(1)
+	var res float64
+	if ls.IsNoneOrNil(2) {
+		res = math.Log(x)
+	} else {
+		switch base := ls.CheckNumber(2); base {
+		case 2:
+			res = math.Log2(x)
+		case 10:
+			res = math.Log10(x)
+		default:
+			res = math.Log(x) / math.Log(base)
+		}
+	}
+	ls.PushNumber(res)
+	return 1
+}
+
+// math.sin (x)
+// http://www.lua.org/manual/5.3/manual.html#pdf-math.sin
+func mathSin(ls api.LuaState) int {
+	ls.PushNumber(math.Sin(ls.CheckNumber(1)))
+	return 1
+}
+
+// math.cos (x)
+// http://www.lua.org/manual/5.3/manual.html#pdf-math.cos
+func mathCos(ls api.LuaState) int {
+	ls.PushNumber(math.Cos(ls.CheckNumber(1)))
+	return 1
+}
+
+// math.tan (x)
+// http://www.lua.org/manual/5.3/manual.html#pdf-math.tan
+func mathTan(ls api.LuaState) int {
+	ls.PushNumber(math.Tan(ls.CheckNumber(1)))
+	return 1
+}
+
+// math.asin (x)
+// http://www.lua.org/manual/5.3/manual.html#pdf-math.asin
+func mathAsin(ls api.LuaState) int {
+	ls.PushNumber(math.Asin(ls.CheckNumber(1)))
+	return 1
+}
+
+// math.acos (x)
+// http://www.lua.org/manual/5.3/manual.html#pdf-math.acos
+func mathAcos(ls api.LuaState) int {
+	ls.PushNumber(math.Acos(ls.CheckNumber(1)))
+	return 1
+}
+
+// math.atan (y [, x])
+// http://www.lua.org/manual/5.3/manual.html#pdf-math.atan
+func mathAtan(ls api.LuaState) int {
+	y := ls.CheckNumber(1)
+	x := ls.OptNumber(2, 1.0)
+	ls.PushNumber(math.Atan2(y, x))
+	return 1
+}
+
+// math.tointeger (x)
+// http://www.lua.org/manual/5.3/manual.html#pdf-math.tointeger
+// lua-5.3.4/src/lmathlib.c#math_toint()
+func mathToInt(ls api.LuaState) int {
+	if i, ok := ls.ToIntegerX(1); ok && ls.Type(1) == api.LUA_TNUMBER {
+		ls.PushInteger(i)
+	} else {
+		ls.CheckAny(1)
+		ls.PushNil() // value is not convertible to integer
+	}
+	return 1
+}
+
+// math.type (x)
+// http://www.lua.org/manual/5.3/manual.html#pdf-math.type
+// lua-5.3.4/src/lmathlib.c#math_type()
+func mathType(ls api.LuaState) int {
+	if ls.Type(1) == api.LUA_TNUMBER {
+		if ls.IsInteger(1) {
+			ls.PushString("integer")
+		} else {
+			ls.PushString("float")
+		}
+	} else {
+		ls.CheckAny(1)
+		ls.PushNil()
+	}
+	return 1
+}
+
+// math.ult (m, n)
+// http://www.lua.org/manual/5.3/manual.html#pdf-math.ult
+func mathUlt(ls api.LuaState) int {
+	m := ls.CheckInteger(1)
+	n := ls.CheckInteger(2)
+	ls.PushBoolean(uint64(m) < uint64(n))
+	return 1
+}
+
+// math.max (x, ···)
+// http://www.lua.org/manual/5.3/manual.html#pdf-math.max
+// lua-5.3.4/src/lmathlib.c#math_max()
+func mathMax(ls api.LuaState) int {
+	n := ls.GetTop() // number of arguments
+	imax := 1        // index of current maximum value
+	ls.ArgCheck(n >= 1, 1, "number expected")
+	for i := 2; i <= n; i++ {
+		if ls.Compare(imax, i, api.LUA_OPLT) {
+			imax = i
+		}
+	}
+	ls.CheckNumber(imax)
+	ls.PushValue(imax)
+	return 1
+}
+
+// math.min (x, ···)
+// http://www.lua.org/manual/5.3/manual.html#pdf-math.min
+// lua-5.3.4/src/lmathlib.c#math_min()
+func mathMin(ls api.LuaState) int {
+	n := ls.GetTop() // number of arguments
+	imin := 1        // index of current minimum value
+	ls.ArgCheck(n >= 1, 1, "number expected")
+	for i := 2; i <= n; i++ {
+		if ls.Compare(i, imin, api.LUA_OPLT) {
+			imin = i
+		}
+	}
+	ls.CheckNumber(imin)
+	ls.PushValue(imin)
+	return 1
+}
+
+// math.random ([m [, n]])
+// http://www.lua.org/manual/5.3/manual.html#pdf-math.random
+// lua-5.3.4/src/lmathlib.c#math_random()
+func mathRandom(ls api.LuaState) int {
+	var low, up int64
+	switch ls.GetTop() { // check number of arguments
+	case 0: // no arguments
+		ls.PushNumber(hostOf(ls).Rand().Float64()) // Number between 0 and 1
+		return 1
+	case 1: // only upper limit
+		low = 1
+		up = ls.CheckInteger(1)
+	case 2: // lower and upper limits
+		low = ls.CheckInteger(1)
+		up = ls.CheckInteger(2)
+	default:
+		return ls.Error2("wrong number of arguments")
+	}
+
+	// random integer in the interval [low, up]
+	ls.ArgCheck(low <= up, 1, "interval is empty")
+	ls.ArgCheck(low >= 0 || up <= math.MaxInt64+low, 1,
+		"interval too large")
+	if up-low == math.MaxInt64 {
+		ls.PushInteger(low + int64(hostOf(ls).Rand().Uint64()>>1))
+	} else {
+		ls.PushInteger(low + hostOf(ls).Rand().Int63n(up-low+1))
+	}
+	return 1
+}
+
+// math.randomseed (x)
+// http://www.lua.org/manual/5.3/manual.html#pdf-math.randomseed
+func mathRandomSeed(ls api.LuaState) int {
+	x := ls.CheckNumber(1)
+	hostOf(ls).Rand().Seed(int64(x))
+	return 0
+}
@@ -0,0 +1,348 @@
+package stdlib
+
+import (
+	"api"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"strings"
+	"time"
+)
+
+// key, in the registry, for the host facilities of the state
+const HOSTKEY = "_HOST"
+
+// host facilities behind the os and math libraries; the state stores them
+// in the registry, they are not part of api.LuaState
+type Host interface {
+	Rand() *rand.Rand // source of math.random
+	Clock() float64   // seconds for os.clock
+	Time() time.Time  // current time for os.time and os.date
+}
+
+func hostOf(ls api.LuaState) Host {
+	ls.GetField(api.LUA_REGISTRYINDEX, HOSTKEY)
+	host, ok := ls.ToUserData(-1).(Host)
+	ls.Pop(1)
+	if !ok {
+		ls.Error2("host facilities not found")
+	}
+	return host
+}
+
+// functions reaching outside the state have no deterministic results
+func checkNotDeterministic(ls api.LuaState, fname string) {
+	if ls.IsDeterministic() {
+		ls.Error2("'%s' not available in deterministic mode", fname)
+	}
+}
+
+var sysLib = api.FuncReg{
+	"clock":    osClock,
+	"date":     osDate,
+	"difftime": osDiffTime,
+	"exit":     osExit,
+	"getenv":   osGetEnv,
+	"remove":   osRemove,
+	"rename":   osRename,
+	"time":     osTime,
+	"tmpname":  osTmpName,
+}
+
+// lua-5.3.4/src/loslib.c#luaopen_os()
+func OpenOSLib(ls api.LuaState) int {
+	ls.NewLib(sysLib)
+	return 1
+}
+
+// os.clock ()
+// http://www.lua.org/manual/5.3/manual.html#pdf-os.clock
+// lua-5.3.4/src/loslib.c#os_clock()
+func osClock(ls api.LuaState) int {
+	ls.PushNumber(hostOf(ls).Clock())
+	return 1
+}
+
+// os.difftime (t2, t1)
+// http://www.lua.org/manual/5.3/manual.html#pdf-os.difftime
+// lua-5.3.4/src/loslib.c#os_difftime()
+func osDiffTime(ls api.LuaState) int {
+	t2 := ls.CheckInteger(1)
+	t1 := ls.CheckInteger(2)
+	ls.PushNumber(float64(t2 - t1))
+	return 1
+}
+
+// os.time ([table])
+// http://www.lua.org/manual/5.3/manual.html#pdf-os.time
+// lua-5.3.4/src/loslib.c#os_time()
+func osTime(ls api.LuaState) int {
+	if ls.IsNoneOrNil(1) { // called without args?
+		ls.PushInteger(hostOf(ls).Time().Unix()) // get current time
+		return 1
+	}
+	ls.CheckType(1, api.LUA_TTABLE)
+	ls.SetTop(1) // make sure table is at the top
+	year := getField(ls, "year", -1, 1900)
+	month := getField(ls, "month", -1, 1)
+	day := getField(ls, "day", -1, 0)
+	hour := getField(ls, "hour", 12, 0)
+	min := getField(ls, "min", 0, 0)
+	sec := getField(ls, "sec", 0, 0)
+	t := time.Date(year+1900, time.Month(month+1), day, hour, min, sec, 0,
+		timeLocation(ls, false))
+	ls.PushInteger(t.Unix())
+	return 1
+}
+
+// lua-5.3.4/src/loslib.c#getfield()
+// reads a date field of the table on top; fields without a default are
+// required. Returns the value minus delta, like 'struct tm' stores it
+func getField(ls api.LuaState, key string, d, delta int) int {
+	t := ls.GetField(-1, key)
+	res, isNum := ls.ToIntegerX(-1)
+	if !isNum { // field is not an integer?
+		if t != api.LUA_TNIL { // some other value?
+			return ls.Error2("field '%s' is not an integer", key)
+		} else if d < 0 { // absent field; no default?
+			return ls.Error2("field '%s' missing in date table", key)
+		}
+		res = int64(d)
+	} else {
+		res -= int64(delta)
+	}
+	ls.Pop(1)
+	return int(res)
+}
+
+// os.date ([format [, time]])
+// http://www.lua.org/manual/5.3/manual.html#pdf-os.date
+// lua-5.3.4/src/loslib.c#os_date()
+func osDate(ls api.LuaState) int {
+	format := ls.OptString(1, "%c")
+	var t time.Time
+	if ls.IsNoneOrNil(2) {
+		t = hostOf(ls).Time()
+	} else {
+		t = time.Unix(ls.CheckInteger(2), 0)
+	}
+	utc := strings.HasPrefix(format, "!")
+	if utc { // UTC?
+		format = format[1:] // skip '!'
+	}
+	t = t.In(timeLocation(ls, utc))
+
+	if strings.HasPrefix(format, "*t") {
+		ls.CreateTable(0, 9) // 9 = number of fields
+		setField(ls, "sec", t.Second())
+		setField(ls, "min", t.Minute())
+		setField(ls, "hour", t.Hour())
+		setField(ls, "day", t.Day())
+		setField(ls, "month", int(t.Month()))
+		setField(ls, "year", t.Year())
+		setField(ls, "wday", int(t.Weekday())+1)
+		setField(ls, "yday", t.YearDay())
+		ls.PushBoolean(t.IsDST())
+		ls.SetField(-2, "isdst")
+	} else {
+		ls.PushString(strftime(ls, format, t))
+	}
+	return 1
+}
+
+func setField(ls api.LuaState, key string, value int) {
+	ls.PushInteger(int64(value))
+	ls.SetField(-2, key)
+}
+
+// local times depend on the host, deterministic states use UTC
+func timeLocation(ls api.LuaState, utc bool) *time.Location {
+	if utc || ls.IsDeterministic() {
+		return time.UTC
+	}
+	return time.Local
+}
+
+// lua-5.3.4/src/loslib.c#checkoption()
+// formats t like C strftime, with the conversions valid in C99
+func strftime(ls api.LuaState, format string, t time.Time) string {
+	var buf strings.Builder
+	for i := 0; i < len(format); i++ {
+		if format[i] != '%' {
+			buf.WriteByte(format[i])
+			continue
+		}
+		i++
+		if i < len(format) && (format[i] == 'E' || format[i] == 'O') {
+			i++ // C99 modifiers, same output in the "C" locale
+		}
+		if i >= len(format) {
+			ls.ArgError(1, "invalid conversion '%' to 'format'")
+		}
+		switch c := format[i]; c {
+		case 'a':
+			buf.WriteString(t.Format("Mon"))
+		case 'A':
+			buf.WriteString(t.Format("Monday"))
+		case 'b', 'h':
+			buf.WriteString(t.Format("Jan"))
+		case 'B':
+			buf.WriteString(t.Format("January"))
+		case 'c':
+			buf.WriteString(t.Format("Mon Jan  2 15:04:05 2006"))
+		case 'C':
+			fmt.Fprintf(&buf, "%02d", t.Year()/100)
+		case 'd':
+			fmt.Fprintf(&buf, "%02d", t.Day())
+		case 'D':
+			buf.WriteString(t.Format("01/02/06"))
+		case 'e':
+			fmt.Fprintf(&buf, "%2d", t.Day())
+		case 'F':
+			buf.WriteString(t.Format("2006-01-02"))
+		case 'g':
+			year, _ := t.ISOWeek()
+			fmt.Fprintf(&buf, "%02d", year%100)
+		case 'G':
+			year, _ := t.ISOWeek()
+			fmt.Fprintf(&buf, "%d", year)
+		case 'H':
+			fmt.Fprintf(&buf, "%02d", t.Hour())
+		case 'I':
+			fmt.Fprintf(&buf, "%02d", (t.Hour()+11)%12+1)
+		case 'j':
+			fmt.Fprintf(&buf, "%03d", t.YearDay())
+		case 'm':
+			fmt.Fprintf(&buf, "%02d", int(t.Month()))
+		case 'M':
+			fmt.Fprintf(&buf, "%02d", t.Minute())
+		case 'n':
+			buf.WriteByte('\n')
+		case 'p':
+			buf.WriteString(t.Format("PM"))
+		case 'r':
+			buf.WriteString(t.Format("03:04:05 PM"))
+		case 'R':
+			buf.WriteString(t.Format("15:04"))
+		case 'S':
+			fmt.Fprintf(&buf, "%02d", t.Second())
+		case 't':
+			buf.WriteByte('\t')
+		case 'T', 'X':
+			buf.WriteString(t.Format("15:04:05"))
+		case 'u':
+			fmt.Fprintf(&buf, "%d", (int(t.Weekday())+6)%7+1)
+		case 'U':
+			fmt.Fprintf(&buf, "%02d", (t.YearDay()+6-int(t.Weekday()))/7)
+		case 'V':
+			_, week := t.ISOWeek()
+			fmt.Fprintf(&buf, "%02d", week)
+		case 'w':
+			fmt.Fprintf(&buf, "%d", int(t.Weekday()))
+		case 'W':
+			fmt.Fprintf(&buf, "%02d", (t.YearDay()+6-(int(t.Weekday())+6)%7)/7)
+		case 'x':
+			buf.WriteString(t.Format("01/02/06"))
+		case 'y':
+			fmt.Fprintf(&buf, "%02d", t.Year()%100)
+		case 'Y':
+			fmt.Fprintf(&buf, "%d", t.Year())
+		case 'z':
+			buf.WriteString(t.Format("-0700"))
+		case 'Z':
+			buf.WriteString(t.Format("MST"))
+		case '%':
+			buf.WriteByte('%')
+		default:
+			ls.ArgError(1, fmt.Sprintf("invalid conversion '%%%c' to 'format'", c))
+		}
+	}
+	return buf.String()
+}
+
+// os.exit ([code [, close]])
+// http://www.lua.org/manual/5.3/manual.html#pdf-os.exit
+// lua-5.3.4/src/loslib.c#os_exit()
+func osExit(ls api.LuaState) int {
+	checkNotDeterministic(ls, "exit")
+	var status int
+	if ls.IsBoolean(1) {
+		if !ls.ToBoolean(1) {
+			status = 1 // EXIT_FAILURE
+		}
+	} else {
+		status = int(ls.OptInteger(1, 0))
+	}
+	os.Exit(status)
+	return 0
+}
+
+// os.getenv (varname)
+// http://www.lua.org/manual/5.3/manual.html#pdf-os.getenv
+// lua-5.3.4/src/loslib.c#os_getenv()
+func osGetEnv(ls api.LuaState) int {
+	name := ls.CheckString(1)
+	if ls.IsDeterministic() { // empty environment
+		ls.PushNil()
+	} else if env, ok := os.LookupEnv(name); ok {
+		ls.PushString(env)
+	} else {
+		ls.PushNil()
+	}
+	return 1
+}
+
+// os.remove (filename)
+// http://www.lua.org/manual/5.3/manual.html#pdf-os.remove
+// lua-5.3.4/src/loslib.c#os_remove()
+func osRemove(ls api.LuaState) int {
+	checkNotDeterministic(ls, "remove")
+	filename := ls.CheckString(1)
+	return fileResult(ls, os.Remove(filename), filename)
+}
+
+// os.rename (oldname, newname)
+// http://www.lua.org/manual/5.3/manual.html#pdf-os.rename
+// lua-5.3.4/src/loslib.c#os_rename()
+func osRename(ls api.LuaState) int {
+	checkNotDeterministic(ls, "rename")
+	oldName := ls.CheckString(1)
+	newName := ls.CheckString(2)
+	return fileResult(ls, os.Rename(oldName, newName), oldName)
+}
+
+// os.tmpname ()
+// http://www.lua.org/manual/5.3/manual.html#pdf-os.tmpname
+// lua-5.3.4/src/loslib.c#os_tmpname()
+func osTmpName(ls api.LuaState) int {
+	checkNotDeterministic(ls, "tmpname")
+	f, err := ioutil.TempFile("", "lua_")
+	if err != nil {
+		return ls.Error2("unable to generate a unique filename")
+	}
+	f.Close()
+	ls.PushString(f.Name())
+	return 1
+}
+
+// lua-5.3.4/src/lauxlib.c#luaL_fileresult()
+func fileResult(ls api.LuaState, err error, fname string) int {
+	if err == nil {
+		ls.PushBoolean(true)
+		return 1
+	}
+	ls.PushNil()
+	if pe, ok := err.(*os.PathError); ok {
+		err = pe.Err
+	} else if le, ok := err.(*os.LinkError); ok {
+		err = le.Err
+	}
+	if fname != "" {
+		ls.PushFString("%s: %s", fname, err.Error())
+	} else {
+		ls.PushString(err.Error())
+	}
+	ls.PushInteger(0) // errno is not available
+	return 3
+}
@@ -0,0 +1,490 @@
+package stdlib
+
+import (
+	"api"
+	"fmt"
+	"math"
+	"strings"
+)
+
+// maximum size of the strings built by the library
+const MAXSIZE = math.MaxInt32
+
+var strLib = api.FuncReg{
+	"byte":    strByte,
+	"char":    strChar,
+	"find":    strFind,
+	"format":  strFormat,
+	"gmatch":  strGmatch,
+	"gsub":    strGsub,
+	"len":     strLen,
+	"lower":   strLower,
+	"match":   strMatch,
+	"rep":     strRep,
+	"reverse": strReverse,
+	"sub":     strSub,
+	"upper":   strUpper,
+}
+
+// lua-5.3.4/src/lstrlib.c#luaopen_string()
+// the library table is also the __index of the string metatable, so the
+// functions a host adds to it become methods of every string
+func OpenStringLib(ls api.LuaState) int {
+	ls.NewLib(strLib)
+	createMetatable(ls)
+	return 1
+}
+
+// lua-5.3.4/src/lstrlib.c#createmetatable()
+func createMetatable(ls api.LuaState) {
+	ls.CreateTable(0, 1)       // table to be metatable for strings
+	ls.PushString("")          // dummy string
+	ls.PushValue(-2)           // copy table
+	ls.SetMetatable(-2)        // set table as metatable for strings
+	ls.Pop(1)                  // pop dummy string
+	ls.PushValue(-2)           // get string library
+	ls.SetField(-2, "__index") // metatable.__index = string
+	ls.Pop(1)                  // pop metatable
+}
+
+// lua-5.3.4/src/lstrlib.c#posrelat()
+// translate a relative string position: negative means back from end
+func posRelat(pos int64, l int) int64 {
+	if pos >= 0 {
+		return pos
+	} else if -pos > int64(l) {
+		return 0
+	}
+	return int64(l) + pos + 1
+}
+
+// string.len (s)
+// http://www.lua.org/manual/5.3/manual.html#pdf-string.len
+func strLen(ls api.LuaState) int {
+	s := ls.CheckString(1)
+	ls.PushInteger(int64(len(s)))
+	return 1
+}
+
+// string.sub (s, i [, j])
+// http://www.lua.org/manual/5.3/manual.html#pdf-string.sub
+// lua-5.3.4/src/lstrlib.c#str_sub()
+func strSub(ls api.LuaState) int {
+	s := ls.CheckString(1)
+	l := len(s)
+	start := posRelat(ls.CheckInteger(2), l)
+	end := posRelat(ls.OptInteger(3, -1), l)
+	if start < 1 {
+		start = 1
+	}
+	if end > int64(l) {
+		end = int64(l)
+	}
+	if start <= end {
+		ls.PushString(s[start-1 : end])
+	} else {
+		ls.PushString("")
+	}
+	return 1
+}
+
+// string.reverse (s)
+// http://www.lua.org/manual/5.3/manual.html#pdf-string.reverse
+func strReverse(ls api.LuaState) int {
+	s := ls.CheckString(1)
+	buf := make([]byte, len(s))
+	for i := 0; i < len(s); i++ {
+		buf[i] = s[len(s)-1-i]
+	}
+	ls.PushString(string(buf))
+	return 1
+}
+
+// string.lower (s)
+// http://www.lua.org/manual/5.3/manual.html#pdf-string.lower
+func strLower(ls api.LuaState) int {
+	s := ls.CheckString(1)
+	buf := []byte(s)
+	for i, c := range buf {
+		if isUpper(c) { // only the "C" locale letters
+			buf[i] = c + ('a' - 'A')
+		}
+	}
+	ls.PushString(string(buf))
+	return 1
+}
+
+// string.upper (s)
+// http://www.lua.org/manual/5.3/manual.html#pdf-string.upper
+func strUpper(ls api.LuaState) int {
+	s := ls.CheckString(1)
+	buf := []byte(s)
+	for i, c := range buf {
+		if isLower(c) { // only the "C" locale letters
+			buf[i] = c - ('a' - 'A')
+		}
+	}
+	ls.PushString(string(buf))
+	return 1
+}
+
+// string.rep (s, n [, sep])
+// http://www.lua.org/manual/5.3/manual.html#pdf-string.rep
+// lua-5.3.4/src/lstrlib.c#str_rep()
+func strRep(ls api.LuaState) int {
+	s := ls.CheckString(1)
+	n := ls.CheckInteger(2)
+	sep := ls.OptString(3, "")
+	if n <= 0 {
+		ls.PushString("")
+	} else if l := int64(len(s) + len(sep)); l > 0 && l > MAXSIZE/n {
+		return ls.Error2("resulting string too large")
+	} else if n == 1 {
+		ls.PushString(s)
+	} else {
+		b := ls.NewBuffer()
+		b.Grow(int(n)*len(s) + int(n-1)*len(sep))
+		for ; n > 1; n-- { // first n-1 copies (followed by separator)
+			b.WriteString(s)
+			b.WriteString(sep)
+		}
+		b.WriteString(s) // last copy (not followed by separator)
+		b.PushResult()
+	}
+	return 1
+}
+
+// string.byte (s [, i [, j]])
+// http://www.lua.org/manual/5.3/manual.html#pdf-string.byte
+// lua-5.3.4/src/lstrlib.c#str_byte()
+func strByte(ls api.LuaState) int {
+	s := ls.CheckString(1)
+	l := len(s)
+	posi := posRelat(ls.OptInteger(2, 1), l)
+	pose := posRelat(ls.OptInteger(3, posi), l)
+	if posi < 1 {
+		posi = 1
+	}
+	if pose > int64(l) {
+		pose = int64(l)
+	}
+	if posi > pose {
+		return 0 // empty interval; return no values
+	}
+	if pose-posi >= math.MaxInt32 { // arithmetic overflow?
+		return ls.Error2("string slice too long")
+	}
+	n := int(pose - posi + 1)
+	ls.CheckStack2(n, "string slice too long")
+	for i := 0; i < n; i++ {
+		ls.PushInteger(int64(s[int(posi)+i-1]))
+	}
+	return n
+}
+
+// string.char (···)
+// http://www.lua.org/manual/5.3/manual.html#pdf-string.char
+// lua-5.3.4/src/lstrlib.c#str_char()
+func strChar(ls api.LuaState) int {
+	n := ls.GetTop() // number of arguments
+	buf := make([]byte, n)
+	for i := 1; i <= n; i++ {
+		c := ls.CheckInteger(i)
+		ls.ArgCheck(uint64(c) <= math.MaxUint8, i, "value out of range")
+		buf[i-1] = byte(c)
+	}
+	ls.PushString(string(buf))
+	return 1
+}
+
+/* FORMAT */
+
+// valid flags in a format specification
+const FMT_FLAGS = "-+ #0"
+
+// string.format (formatstring, ···)
+// http://www.lua.org/manual/5.3/manual.html#pdf-string.format
+// lua-5.3.4/src/lstrlib.c#str_format()
+func strFormat(ls api.LuaState) int {
+	top := ls.GetTop()
+	arg := 1
+	strfrmt := ls.CheckString(arg)
+	b := ls.NewBuffer()
+	for i := 0; i < len(strfrmt); i++ {
+		if strfrmt[i] != L_ESC {
+			b.WriteByte(strfrmt[i])
+			continue
+		}
+		if i++; i < len(strfrmt) && strfrmt[i] == L_ESC {
+			b.WriteByte(L_ESC) // %%
+			continue
+		}
+		// format item
+		if arg++; arg > top {
+			ls.ArgError(arg, "no value")
+		}
+		form, conv := scanFormat(ls, strfrmt[i:])
+		i += len(form) - 1
+		switch conv {
+		case 'c':
+			c := byte(ls.CheckInteger(arg))
+			b.WriteString(padString(form, string([]byte{c})))
+		case 'd', 'i':
+			n := ls.CheckInteger(arg)
+			b.WriteString(fmt.Sprintf(form+"d", n))
+		case 'o', 'u', 'x', 'X':
+			n := uint64(ls.CheckInteger(arg)) // converted as unsigned, like C
+			if conv == 'u' {
+				conv = 'd'
+			}
+			b.WriteString(fmt.Sprintf(form+string(conv), n))
+		case 'a', 'A':
+			b.WriteString(formatHexFloat(form, conv, ls.CheckNumber(arg)))
+		case 'e', 'E', 'f', 'F', 'g', 'G':
+			b.WriteString(formatFloat(form, conv, ls.CheckNumber(arg)))
+		case 'q':
+			addLiteral(ls, b, arg)
+		case 's':
+			s := ls.ToString2(arg)
+			ls.Pop(1) // remove result from 'ToString2'
+			if form == "%" {
+				b.WriteString(s) // no modifiers: keep entire string
+			} else {
+				ls.ArgCheck(strings.IndexByte(s, 0) < 0, arg, "string contains zeros")
+				if !strings.Contains(form, ".") && len(s) >= 100 {
+					// no precision and string is too long to be formatted
+					b.WriteString(s) // keep entire string
+				} else {
+					b.WriteString(padString(form, s))
+				}
+			}
+		default: // also treat cases 'pnLlh'
+			return ls.Error2("invalid option '%%%c' to 'format'", conv)
+		}
+	}
+	b.PushResult()
+	return 1
+}
+
+// lua-5.3.4/src/lstrlib.c#scanformat()
+// returns the specification ('%' followed by flags, width and precision)
+// of the format item at the start of strfrmt, and its conversion
+func scanFormat(ls api.LuaState, strfrmt string) (string, byte) {
+	p := 0
+	for p < len(strfrmt) && strings.IndexByte(FMT_FLAGS, strfrmt[p]) >= 0 {
+		p++ // skip flags
+	}
+	if p > len(FMT_FLAGS) {
+		ls.Error2("invalid format (repeated flags)")
+	}
+	p = skipDigits(strfrmt, p) // skip width
+	if p < len(strfrmt) && strfrmt[p] == '.' {
+		p++
+		p = skipDigits(strfrmt, p) // skip precision
+	}
+	if p < len(strfrmt) && isDigit(strfrmt[p]) {
+		ls.Error2("invalid format (width or precision too long)")
+	}
+	if p == len(strfrmt) {
+		ls.Error2("invalid option '%%' to 'format'")
+	}
+	return "%" + strfrmt[:p], strfrmt[p]
+}
+
+// skips at most 2 digits
+func skipDigits(s string, p int) int {
+	for i := 0; i < 2 && p < len(s) && isDigit(s[p]); i++ {
+		p++
+	}
+	return p
+}
+
+// parses the flags, width and precision of a format specification;
+// prec is -1 if absent
+func parseFormat(form string) (flags string, width, prec int) {
+	p := 1 // skip '%'
+	for p < len(form) && strings.IndexByte(FMT_FLAGS, form[p]) >= 0 {
+		p++
+	}
+	flags = form[1:p]
+	for ; p < len(form) && isDigit(form[p]); p++ {
+		width = width*10 + int(form[p]-'0')
+	}
+	prec = -1
+	if p < len(form) && form[p] == '.' {
+		prec = 0
+		for p++; p < len(form) && isDigit(form[p]); p++ {
+			prec = prec*10 + int(form[p]-'0')
+		}
+	}
+	return
+}
+
+// formats a string like C does: width and precision count bytes, not runes
+func padString(form, s string) string {
+	flags, width, prec := parseFormat(form)
+	if prec >= 0 && prec < len(s) {
+		s = s[:prec]
+	}
+	if pad := width - len(s); pad > 0 {
+		if strings.IndexByte(flags, '-') >= 0 {
+			return s + strings.Repeat(" ", pad)
+		}
+		return strings.Repeat(" ", pad) + s
+	}
+	return s
+}
+
+// formats inf and nan like C does, they are never padded with zeros
+func formatNonFinite(form string, conv byte, n float64) string {
+	var s string
+	if math.IsNaN(n) {
+		s = "nan"
+	} else if n > 0 {
+		s = "inf"
+	} else {
+		s = "-inf"
+	}
+	flags, width, _ := parseFormat(form)
+	if s[0] != '-' {
+		if strings.IndexByte(flags, '+') >= 0 {
+			s = "+" + s
+		} else if strings.IndexByte(flags, ' ') >= 0 {
+			s = " " + s
+		}
+	}
+	if isUpper(conv) {
+		s = strings.ToUpper(s)
+	}
+	if strings.IndexByte(flags, '-') >= 0 {
+		return fmt.Sprintf("%-*s", width, s)
+	}
+	return fmt.Sprintf("%*s", width, s)
+}
+
+func formatFloat(form string, conv byte, n float64) string {
+	if math.IsInf(n, 0) || math.IsNaN(n) {
+		return formatNonFinite(form, conv, n)
+	}
+	if (conv == 'g' || conv == 'G') && !strings.Contains(form, ".") {
+		form += ".6" // C default, Go would use the smallest precision
+	}
+	return fmt.Sprintf(form+string(conv), n)
+}
+
+// lua-5.3.4/src/lstrlib.c#lua_number2strx()
+func formatHexFloat(form string, conv byte, n float64) string {
+	if math.IsInf(n, 0) || math.IsNaN(n) {
+		return formatNonFinite(form, conv, n)
+	}
+	verb := byte('x')
+	if conv == 'A' {
+		verb = 'X'
+	}
+	s := fmt.Sprintf(form+string(verb), n)
+	// C writes the exponent with as few digits as possible
+	if i := strings.IndexAny(s, "pP"); i >= 0 && i+3 < len(s) && s[i+2] == '0' {
+		e := strings.TrimLeft(s[i+2:], "0")
+		if e == "" || !isDigit(e[0]) {
+			e = "0" + e
+		}
+		s = s[:i+2] + e
+	}
+	return s
+}
+
+// lua-5.3.4/src/lstrlib.c#addquoted()
+func addQuoted(b api.Buffer, s string) {
+	b.WriteByte('"')
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c == '"' || c == '\\' || c == '\n' {
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		} else if isCntrl(c) {
+			if i+1 < len(s) && isDigit(s[i+1]) {
+				fmt.Fprintf(b, "\\%03d", c)
+			} else {
+				fmt.Fprintf(b, "\\%d", c)
+			}
+		} else {
+			b.WriteByte(c)
+		}
+	}
+	b.WriteByte('"')
+}
+
+// lua-5.3.4/src/lstrlib.c#addliteral()
+func addLiteral(ls api.LuaState, b api.Buffer, arg int) {
+	switch ls.Type(arg) {
+	case api.LUA_TSTRING:
+		addQuoted(b, ls.ToString(arg))
+	case api.LUA_TNUMBER:
+		if !ls.IsInteger(arg) { // float?
+			n := ls.ToNumber(arg)
+			if math.IsInf(n, 1) {
+				b.WriteString("1e9999")
+			} else if math.IsInf(n, -1) {
+				b.WriteString("-1e9999")
+			} else if n != n {
+				b.WriteString("(0/0)")
+			} else { // format number as hexadecimal, to preserve precision
+				b.WriteString(formatHexFloat("%", 'a', n))
+			}
+		} else { // integers
+			n := ls.ToInteger(arg)
+			if n == math.MinInt64 { // corner case?
+				fmt.Fprintf(b, "0x%x", uint64(n))
+			} else {
+				fmt.Fprintf(b, "%d", n)
+			}
+		}
+	case api.LUA_TNIL, api.LUA_TBOOLEAN:
+		b.WriteString(ls.ToString2(arg))
+		ls.Pop(1)
+	default:
+		ls.ArgError(arg, "value has no literal form")
+	}
+}
+
+/* character classes of the "C" locale */
+
+func isDigit(c byte) bool {
+	return '0' <= c && c <= '9'
+}
+
+func isLower(c byte) bool {
+	return 'a' <= c && c <= 'z'
+}
+
+func isUpper(c byte) bool {
+	return 'A' <= c && c <= 'Z'
+}
+
+func isAlpha(c byte) bool {
+	return isLower(c) || isUpper(c)
+}
+
+func isAlnum(c byte) bool {
+	return isAlpha(c) || isDigit(c)
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || '\t' <= c && c <= '\r'
+}
+
+func isCntrl(c byte) bool {
+	return c < ' ' || c == 0x7f
+}
+
+func isGraph(c byte) bool {
+	return '!' <= c && c <= '~'
+}
+
+func isPunct(c byte) bool {
+	return isGraph(c) && !isAlnum(c)
+}
+
+func isXDigit(c byte) bool {
+	return isDigit(c) || 'a' <= c && c <= 'f' || 'A' <= c && c <= 'F'
+}
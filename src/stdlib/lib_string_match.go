@@ -0,0 +1,591 @@
+package stdlib
+
+import (
+	"api"
+	"strings"
+)
+
+/* PATTERN MATCHING */
+
+const (
+	LUA_MAXCAPTURES = 32
+	CAP_UNFINISHED  = -1
+	CAP_POSITION    = -2
+	MAXCCALLS       = 200 // maximum recursion depth for 'match'
+	L_ESC           = '%'
+	SPECIALS        = "^$*+?.([%-"
+)
+
+// positions in src and p are byte offsets, -1 stands for C's NULL
+type matchState struct {
+	ls         api.LuaState
+	src        string
+	p          string
+	matchdepth int // control for recursive depth (to avoid Go stack overflow)
+	level      int // total number of captures (finished or unfinished)
+	capture    [LUA_MAXCAPTURES]struct {
+		init int
+		len  int
+	}
+}
+
+// lua-5.3.4/src/lstrlib.c#prepstate()
+func newMatchState(ls api.LuaState, s, p string) *matchState {
+	return &matchState{ls: ls, src: s, p: p}
+}
+
+// lua-5.3.4/src/lstrlib.c#reprepstate()
+func (self *matchState) reprepstate() {
+	self.level = 0
+	self.matchdepth = MAXCCALLS
+}
+
+// lua-5.3.4/src/lstrlib.c#check_capture()
+func (self *matchState) checkCapture(l byte) int {
+	i := int(l) - '1'
+	if i < 0 || i >= self.level || self.capture[i].len == CAP_UNFINISHED {
+		self.ls.Error2("invalid capture index %%%d", i+1)
+	}
+	return i
+}
+
+// lua-5.3.4/src/lstrlib.c#capture_to_close()
+func (self *matchState) captureToClose() int {
+	level := self.level
+	for level--; level >= 0; level-- {
+		if self.capture[level].len == CAP_UNFINISHED {
+			return level
+		}
+	}
+	self.ls.Error2("invalid pattern capture")
+	return 0
+}
+
+// lua-5.3.4/src/lstrlib.c#classEnd()
+func (self *matchState) classEnd(p int) int {
+	c := self.p[p]
+	p++
+	if c == L_ESC {
+		if p >= len(self.p) {
+			self.ls.Error2("malformed pattern (ends with '%%')")
+		}
+		return p + 1
+	}
+	if c == '[' {
+		if p < len(self.p) && self.p[p] == '^' {
+			p++
+		}
+		for { // look for a ']'
+			if p >= len(self.p) {
+				self.ls.Error2("malformed pattern (missing ']')")
+			}
+			c := self.p[p]
+			p++
+			if c == L_ESC && p < len(self.p) {
+				p++ // skip escapes (e.g. '%]')
+			}
+			if p < len(self.p) && self.p[p] == ']' {
+				break
+			}
+		}
+		return p + 1
+	}
+	return p
+}
+
+// lua-5.3.4/src/lstrlib.c#match_class()
+func matchClass(c, cl byte) bool {
+	var res bool
+	switch cl | ('a' - 'A') { // tolower
+	case 'a':
+		res = isAlpha(c)
+	case 'c':
+		res = isCntrl(c)
+	case 'd':
+		res = isDigit(c)
+	case 'g':
+		res = isGraph(c)
+	case 'l':
+		res = isLower(c)
+	case 'p':
+		res = isPunct(c)
+	case 's':
+		res = isSpace(c)
+	case 'u':
+		res = isUpper(c)
+	case 'w':
+		res = isAlnum(c)
+	case 'x':
+		res = isXDigit(c)
+	default:
+		return cl == c
+	}
+	if isUpper(cl) {
+		return !res
+	}
+	return res
+}
+
+// lua-5.3.4/src/lstrlib.c#matchbracketclass()
+// p is the position of '[' and ec the position of the closing ']'
+func (self *matchState) matchBracketClass(c byte, p, ec int) bool {
+	sig := true
+	if self.p[p+1] == '^' {
+		sig = false
+		p++ // skip the '^'
+	}
+	for p++; p < ec; p++ {
+		if self.p[p] == L_ESC {
+			p++
+			if matchClass(c, self.p[p]) {
+				return sig
+			}
+		} else if self.p[p+1] == '-' && p+2 < ec {
+			p += 2
+			if self.p[p-2] <= c && c <= self.p[p] {
+				return sig
+			}
+		} else if self.p[p] == c {
+			return sig
+		}
+	}
+	return !sig
+}
+
+// lua-5.3.4/src/lstrlib.c#singlematch()
+func (self *matchState) singleMatch(s, p, ep int) bool {
+	if s >= len(self.src) {
+		return false
+	}
+	c := self.src[s]
+	switch self.p[p] {
+	case '.':
+		return true // matches any char
+	case L_ESC:
+		return matchClass(c, self.p[p+1])
+	case '[':
+		return self.matchBracketClass(c, p, ep-1)
+	default:
+		return self.p[p] == c
+	}
+}
+
+// lua-5.3.4/src/lstrlib.c#matchbalance()
+func (self *matchState) matchBalance(s, p int) int {
+	if p+1 >= len(self.p) {
+		self.ls.Error2("malformed pattern (missing arguments to '%%b')")
+	}
+	if s >= len(self.src) || self.src[s] != self.p[p] {
+		return -1
+	}
+	b, e := self.p[p], self.p[p+1]
+	cont := 1
+	for s++; s < len(self.src); s++ {
+		if self.src[s] == e {
+			if cont--; cont == 0 {
+				return s + 1
+			}
+		} else if self.src[s] == b {
+			cont++
+		}
+	}
+	return -1 // string ends out of balance
+}
+
+// lua-5.3.4/src/lstrlib.c#max_expand()
+func (self *matchState) maxExpand(s, p, ep int) int {
+	i := 0 // counts maximum expand for item
+	for self.singleMatch(s+i, p, ep) {
+		i++
+	}
+	// keeps trying to match with the maximum repetitions
+	for ; i >= 0; i-- {
+		if res := self.match(s+i, ep+1); res != -1 {
+			return res
+		}
+	}
+	return -1
+}
+
+// lua-5.3.4/src/lstrlib.c#min_expand()
+func (self *matchState) minExpand(s, p, ep int) int {
+	for {
+		if res := self.match(s, ep+1); res != -1 {
+			return res
+		} else if self.singleMatch(s, p, ep) {
+			s++ // try with one more repetition
+		} else {
+			return -1
+		}
+	}
+}
+
+// lua-5.3.4/src/lstrlib.c#start_capture()
+func (self *matchState) startCapture(s, p, what int) int {
+	level := self.level
+	if level >= LUA_MAXCAPTURES {
+		self.ls.Error2("too many captures")
+	}
+	self.capture[level].init = s
+	self.capture[level].len = what
+	self.level = level + 1
+	res := self.match(s, p)
+	if res == -1 { // match failed?
+		self.level-- // undo capture
+	}
+	return res
+}
+
+// lua-5.3.4/src/lstrlib.c#end_capture()
+func (self *matchState) endCapture(s, p int) int {
+	l := self.captureToClose()
+	self.capture[l].len = s - self.capture[l].init // close capture
+	res := self.match(s, p)
+	if res == -1 { // match failed?
+		self.capture[l].len = CAP_UNFINISHED // undo capture
+	}
+	return res
+}
+
+// lua-5.3.4/src/lstrlib.c#match_capture()
+func (self *matchState) matchCapture(s int, l byte) int {
+	i := self.checkCapture(l)
+	init, n := self.capture[i].init, self.capture[i].len
+	if len(self.src)-s >= n && self.src[init:init+n] == self.src[s:s+n] {
+		return s + n
+	}
+	return -1
+}
+
+// lua-5.3.4/src/lstrlib.c#match()
+// returns the end of the match of p at s, or -1
+func (self *matchState) match(s, p int) int {
+	if self.matchdepth == 0 {
+		self.ls.Error2("pattern too complex")
+	}
+	self.matchdepth--
+loop:
+	for p != len(self.p) { // end of pattern?
+		switch self.p[p] {
+		case '(': // start capture
+			if p+1 < len(self.p) && self.p[p+1] == ')' { // position capture?
+				s = self.startCapture(s, p+2, CAP_POSITION)
+			} else {
+				s = self.startCapture(s, p+1, CAP_UNFINISHED)
+			}
+			break loop
+		case ')': // end capture
+			s = self.endCapture(s, p+1)
+			break loop
+		case '$':
+			if p+1 == len(self.p) { // is the '$' the last char in pattern?
+				if s != len(self.src) { // check end of string
+					s = -1
+				}
+				break loop
+			} // else go to default
+		case L_ESC: // escaped sequences not in the format class[*+?-]?
+			if p+1 < len(self.p) {
+				switch self.p[p+1] {
+				case 'b': // balanced string?
+					if s = self.matchBalance(s, p+2); s != -1 {
+						p += 4
+						continue loop // return match(ms, s, p + 4);
+					} // else fail (s == NULL)
+					break loop
+				case 'f': // frontier?
+					p += 2
+					if p >= len(self.p) || self.p[p] != '[' {
+						self.ls.Error2("missing '[' after '%%f' in pattern")
+					}
+					ep := self.classEnd(p) // points to what is next
+					var prev, cur byte
+					if s > 0 {
+						prev = self.src[s-1]
+					}
+					if s < len(self.src) {
+						cur = self.src[s]
+					}
+					if !self.matchBracketClass(prev, p, ep-1) &&
+						self.matchBracketClass(cur, p, ep-1) {
+						p = ep
+						continue loop // return match(ms, s, ep);
+					}
+					s = -1 // match failed
+					break loop
+				case '0', '1', '2', '3', '4', '5', '6', '7', '8', '9': // capture results (%0-%9)?
+					if s = self.matchCapture(s, self.p[p+1]); s != -1 {
+						p += 2
+						continue loop // return match(ms, s, p + 2)
+					}
+					break loop
+				} // else go to default
+			}
+		}
+		// default: pattern class plus optional suffix
+		ep := self.classEnd(p) // points to optional suffix
+		var epc byte
+		if ep < len(self.p) {
+			epc = self.p[ep]
+		}
+		// does not match at least once?
+		if !self.singleMatch(s, p, ep) {
+			if epc == '*' || epc == '?' || epc == '-' { // accept empty?
+				p = ep + 1
+				continue loop // return match(ms, s, ep + 1);
+			}
+			s = -1 // '+' or no suffix: fail
+		} else { // matched once
+			switch epc { // handle optional suffix
+			case '?': // optional
+				if res := self.match(s+1, ep+1); res != -1 {
+					s = res
+				} else {
+					p = ep + 1
+					continue loop // else return match(ms, s, ep + 1);
+				}
+			case '+': // 1 or more repetitions
+				s = self.maxExpand(s+1, p, ep) // 1 match already done
+			case '*': // 0 or more repetitions
+				s = self.maxExpand(s, p, ep)
+			case '-': // 0 or more repetitions (minimum)
+				s = self.minExpand(s, p, ep)
+			default: // no suffix
+				s++
+				p = ep
+				continue loop // return match(ms, s + 1, ep);
+			}
+		}
+		break loop
+	}
+	self.matchdepth++
+	return s
+}
+
+// lua-5.3.4/src/lstrlib.c#push_onecapture()
+func (self *matchState) pushOneCapture(i, s, e int) {
+	if i >= self.level {
+		if i == 0 { // ms->level == 0, too
+			self.ls.PushString(self.src[s:e]) // add whole match
+		} else {
+			self.ls.Error2("invalid capture index %%%d", i+1)
+		}
+	} else {
+		init, l := self.capture[i].init, self.capture[i].len
+		if l == CAP_UNFINISHED {
+			self.ls.Error2("unfinished capture")
+		}
+		if l == CAP_POSITION {
+			self.ls.PushInteger(int64(init + 1))
+		} else {
+			self.ls.PushString(self.src[init : init+l])
+		}
+	}
+}
+
+// lua-5.3.4/src/lstrlib.c#push_captures()
+// s is -1 when the whole match must not be pushed
+func (self *matchState) pushCaptures(s, e int) int {
+	nLevels := self.level
+	if nLevels == 0 && s != -1 {
+		nLevels = 1
+	}
+	self.ls.CheckStack2(nLevels, "too many captures")
+	for i := 0; i < nLevels; i++ {
+		self.pushOneCapture(i, s, e)
+	}
+	return nLevels // number of strings pushed
+}
+
+// lua-5.3.4/src/lstrlib.c#nospecials()
+// check whether pattern has no special characters
+func noSpecials(p string) bool {
+	return !strings.ContainsAny(p, SPECIALS)
+}
+
+// lua-5.3.4/src/lstrlib.c#str_find_aux()
+func strFindAux(ls api.LuaState, find bool) int {
+	s := ls.CheckString(1)
+	p := ls.CheckString(2)
+	init := posRelat(ls.OptInteger(3, 1), len(s))
+	if init < 1 {
+		init = 1
+	} else if init > int64(len(s))+1 { // start after string's end?
+		ls.PushNil() // cannot find anything
+		return 1
+	}
+	// explicit request or no special characters?
+	if find && (ls.ToBoolean(4) || noSpecials(p)) {
+		// do a plain search
+		if idx := strings.Index(s[init-1:], p); idx >= 0 {
+			ls.PushInteger(init + int64(idx))
+			ls.PushInteger(init + int64(idx+len(p)) - 1)
+			return 2
+		}
+	} else {
+		anchor := len(p) > 0 && p[0] == '^'
+		if anchor {
+			p = p[1:] // skip anchor character
+		}
+		ms := newMatchState(ls, s, p)
+		for s1 := int(init - 1); ; s1++ {
+			ms.reprepstate()
+			if e := ms.match(s1, 0); e != -1 {
+				if find {
+					ls.PushInteger(int64(s1 + 1)) // start
+					ls.PushInteger(int64(e))      // end
+					return ms.pushCaptures(-1, 0) + 2
+				}
+				return ms.pushCaptures(s1, e)
+			}
+			if s1 >= len(s) || anchor {
+				break
+			}
+		}
+	}
+	ls.PushNil() // not found
+	return 1
+}
+
+// string.find (s, pattern [, init [, plain]])
+// http://www.lua.org/manual/5.3/manual.html#pdf-string.find
+func strFind(ls api.LuaState) int {
+	return strFindAux(ls, true)
+}
+
+// string.match (s, pattern [, init])
+// http://www.lua.org/manual/5.3/manual.html#pdf-string.match
+func strMatch(ls api.LuaState) int {
+	return strFindAux(ls, false)
+}
+
+// state for 'gmatch'
+type gmatchState struct {
+	src       int // current position
+	lastMatch int // end of last match
+	ms        *matchState
+}
+
+// string.gmatch (s, pattern)
+// http://www.lua.org/manual/5.3/manual.html#pdf-string.gmatch
+// lua-5.3.4/src/lstrlib.c#gmatch()
+func strGmatch(ls api.LuaState) int {
+	s := ls.CheckString(1)
+	p := ls.CheckString(2)
+	ls.SetTop(2) // keep them on closure to avoid being collected
+	ls.NewUserData(&gmatchState{
+		src:       0,
+		lastMatch: -1,
+		ms:        newMatchState(ls, s, p),
+	})
+	ls.PushGoClosure(gmatchAux, 3)
+	return 1
+}
+
+// lua-5.3.4/src/lstrlib.c#gmatch_aux()
+func gmatchAux(ls api.LuaState) int {
+	gm := ls.ToUserData(ls.UpvalueIndex(3)).(*gmatchState)
+	ms := gm.ms
+	ms.ls = ls // may be called from another thread
+	for src := gm.src; src <= len(ms.src); src++ {
+		ms.reprepstate()
+		if e := ms.match(src, 0); e != -1 && e != gm.lastMatch {
+			gm.src = e
+			gm.lastMatch = e
+			return ms.pushCaptures(src, e)
+		}
+	}
+	return 0 // not found
+}
+
+// lua-5.3.4/src/lstrlib.c#add_s()
+func (self *matchState) addS(b api.Buffer, s, e int) {
+	news := self.ls.ToString(3)
+	for i := 0; i < len(news); i++ {
+		if news[i] != L_ESC {
+			b.WriteByte(news[i])
+			continue
+		}
+		i++ // skip ESC
+		if i == len(news) || !isDigit(news[i]) {
+			if i == len(news) || news[i] != L_ESC {
+				self.ls.Error2("invalid use of '%c' in replacement string", L_ESC)
+			}
+			b.WriteByte(news[i])
+		} else if news[i] == '0' {
+			b.WriteString(self.src[s:e])
+		} else {
+			self.pushOneCapture(int(news[i]-'1'), s, e)
+			b.WriteString(self.ls.ToString2(-1)) // if number, convert it to string
+			self.ls.Pop(2)                       // remove it and its string
+		}
+	}
+}
+
+// lua-5.3.4/src/lstrlib.c#add_value()
+func (self *matchState) addValue(b api.Buffer, s, e int, tr api.LuaType) {
+	ls := self.ls
+	switch tr {
+	case api.LUA_TFUNCTION:
+		ls.PushValue(3)
+		n := self.pushCaptures(s, e)
+		ls.Call(n, 1)
+	case api.LUA_TTABLE:
+		self.pushOneCapture(0, s, e)
+		ls.GetTable(3)
+	default: // LUA_TNUMBER or LUA_TSTRING
+		self.addS(b, s, e)
+		return
+	}
+	if !ls.ToBoolean(-1) { // nil or false?
+		ls.Pop(1)
+		b.WriteString(self.src[s:e]) // keep original text
+	} else if !ls.IsString(-1) {
+		ls.Error2("invalid replacement value (a %s)", ls.TypeName2(-1))
+	} else {
+		b.WriteString(ls.ToString(-1)) // add result to accumulator
+		ls.Pop(1)
+	}
+}
+
+// string.gsub (s, pattern, repl [, n])
+// http://www.lua.org/manual/5.3/manual.html#pdf-string.gsub
+// lua-5.3.4/src/lstrlib.c#str_gsub()
+func strGsub(ls api.LuaState) int {
+	src := ls.CheckString(1)
+	p := ls.CheckString(2)
+	tr := ls.Type(3)                            // replacement type
+	maxS := ls.OptInteger(4, int64(len(src))+1) // max replacements
+	ls.ArgCheck(tr == api.LUA_TNUMBER || tr == api.LUA_TSTRING ||
+		tr == api.LUA_TFUNCTION || tr == api.LUA_TTABLE, 3,
+		"string/function/table expected")
+	anchor := len(p) > 0 && p[0] == '^'
+	if anchor {
+		p = p[1:] // skip anchor character
+	}
+	b := ls.NewBuffer()
+	ms := newMatchState(ls, src, p)
+	s, lastMatch := 0, -1
+	n := int64(0)
+	for n < maxS {
+		ms.reprepstate()
+		if e := ms.match(s, 0); e != -1 && e != lastMatch { // match?
+			n++
+			ms.addValue(b, s, e, tr) // add replacement to buffer
+			s = e
+			lastMatch = e
+		} else if s < len(src) { // otherwise, skip one character
+			b.WriteByte(src[s])
+			s++
+		} else {
+			break // end of subject
+		}
+		if anchor {
+			break
+		}
+	}
+	b.WriteString(src[s:])
+	b.PushResult()
+	ls.PushInteger(n) // number of substitutions
+	return 2
+}
@@ -66,6 +66,10 @@ func (self Instruction) OpMode() byte {
 	return opcodes[self.OpCode()].opMode
 }
 
+func (self Instruction) SetsA() bool {
+	return opcodes[self.OpCode()].setAFlag != 0
+}
+
 func (self Instruction) BMode() byte {
 	return opcodes[self.OpCode()].argBMode
 }
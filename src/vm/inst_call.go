@@ -38,14 +38,13 @@ func tForLoop(i Instruction, vm api.LuaVM) {
 	}
 }
 
-// todo tail call optimization?
 func tailCall(i Instruction, vm api.LuaVM) {
-	a, b, c := i.ABC()
+	a, b, _ := i.ABC()
 	a++
-	c = 0
 	nArgs := pushFuncAndArgs(a, b, vm)
-	vm.Call(nArgs, c-1)
-	popResults(a, c, vm)
+	if !vm.TailCall(nArgs) { // Go function, results are pushed
+		popResults(a, 0, vm)
+	}
 }
 
 func closure(i Instruction, vm api.LuaVM) {
@@ -63,6 +63,13 @@ func not(i Instruction, vm api.LuaVM) {
 func forPrep(i Instruction, vm api.LuaVM) {
 	a, sBx := i.AsBx()
 	a++
+	if !vm.IsNumber(a) {
+		forError(vm, "initial value")
+	} else if !vm.IsNumber(a + 1) {
+		forError(vm, "limit")
+	} else if !vm.IsNumber(a + 2) {
+		forError(vm, "step")
+	}
 	vm.PushValue(a)
 	vm.PushValue(a + 2)
 	vm.Arith(api.LUA_OPSUB)
@@ -70,6 +77,14 @@ func forPrep(i Instruction, vm api.LuaVM) {
 	vm.AddPC(sBx)
 }
 
+// raises "'for' initial value must be a number" and the like
+func forError(vm api.LuaVM, what string) {
+	vm.Where(0)
+	vm.PushFString("'for' %s must be a number", what)
+	vm.Concat(2)
+	vm.Error()
+}
+
 func forLoop(i Instruction, vm api.LuaVM) {
 	a, sBx := i.AsBx()
 	a++